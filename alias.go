@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// variableAlias copies the value given for one name to several declared
+// variables, for --alias.
+type variableAlias struct {
+	From string
+	To   []string
+}
+
+// parseAliases parses the given --alias arguments, each of which is a source
+// name and a comma-separated list of target variables separated by an equals
+// sign. Each target must be declared in the given module.
+func parseAliases(diags []tfconfig.Diagnostic, specs []string, mod *tfconfig.Module) ([]variableAlias, []tfconfig.Diagnostic) {
+	aliases := make([]variableAlias, 0, len(specs))
+	for _, spec := range specs {
+		eq := strings.Index(spec, "=")
+		if eq < 1 || eq == len(spec)-1 {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Invalid variable alias",
+				Detail:   fmt.Sprintf("The alias %q must be a variable name and a comma-separated list of target variable names separated by an equals sign, like 'region=primary_region,replica_region'.", spec),
+			})
+			continue
+		}
+		alias := variableAlias{
+			From: spec[:eq],
+			To:   strings.Split(spec[eq+1:], ","),
+		}
+		valid := true
+		for _, name := range alias.To {
+			if _, declared := mod.Variables[name]; !declared {
+				diags = append(diags, tfconfig.Diagnostic{
+					Severity: tfconfig.DiagError,
+					Summary:  "Undeclared alias target",
+					Detail:   fmt.Sprintf("The alias %q targets %q, but the module doesn't declare a variable of that name.", spec, name),
+				})
+				valid = false
+			}
+		}
+		if valid {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases, diags
+}
+
+// applyAliases copies the value given for the source name of each of the
+// given aliases into each of its target variables, modifying the result
+// in-place. The given sources must be the same ones that produced the result.
+//
+// A target variable that has a value of its own in a source keeps that
+// value, both in the merged result and in the per-source results.
+func applyAliases(diags []tfconfig.Diagnostic, aliases []variableAlias, result *filtervars.Result, sources []*filtervars.Source) []tfconfig.Diagnostic {
+	for _, alias := range aliases {
+		from, ok := result.Values[alias.From]
+		if !ok {
+			from, ok = result.Undeclared[alias.From]
+		}
+		if !ok {
+			continue
+		}
+
+		for _, name := range alias.To {
+			if _, exists := result.Values[name]; exists {
+				continue
+			}
+			attr, syntaxAttr, moreDiags := aliasAttribute(from, name)
+			diags = append(diags, moreDiags...)
+			if attr == nil {
+				continue
+			}
+			result.Attrs[name] = attr
+			result.Values[name] = syntaxAttr
+			result.Definitions[name] = append(result.Definitions[name], filtervars.Position{
+				Filename: from.NameRange.Filename,
+				Line:     from.NameRange.Start.Line,
+				Column:   from.NameRange.Start.Column,
+			})
+		}
+
+		for i, src := range sources {
+			if src == nil {
+				continue
+			}
+			srcFrom, ok := src.SyntaxBody.Attributes[alias.From]
+			if !ok {
+				continue
+			}
+			for _, name := range alias.To {
+				if _, exists := src.SyntaxBody.Attributes[name]; exists {
+					continue
+				}
+				if attr, _, _ := aliasAttribute(srcFrom, name); attr != nil {
+					result.SourceAttrs[i][name] = attr
+				}
+			}
+		}
+	}
+	return diags
+}
+
+// aliasAttribute returns new definitions of the given name with the value of
+// the given attribute, retaining its source position.
+func aliasAttribute(from *hclsyntax.Attribute, name string) (*hclwrite.Attribute, *hclsyntax.Attribute, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	val, hclDiags := from.Expr.Value(nil)
+	diags = appendHCLDiags(diags, hclDiags)
+	if hclDiags.HasErrors() {
+		return nil, nil, diags
+	}
+
+	// SetAttributeValue doesn't return the new attribute when it's newly
+	// created, so we look it up again afterwards.
+	f := hclwrite.NewEmptyFile()
+	f.Body().SetAttributeValue(name, val)
+	attr := f.Body().GetAttribute(name)
+	syntaxAttr := &hclsyntax.Attribute{
+		Name: name,
+		Expr: &hclsyntax.LiteralValueExpr{
+			Val:      val,
+			SrcRange: from.Expr.Range(),
+		},
+		SrcRange:  from.SrcRange,
+		NameRange: from.NameRange,
+	}
+	return attr, syntaxAttr, diags
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// autoLoadFiles returns the paths of the files in the given module directory
+// that Terraform would load automatically, in the order it would load them:
+// terraform.tfvars, terraform.tfvars.json, and then any files whose names
+// end in .auto.tfvars or .auto.tfvars.json, in lexical order.
+//
+// The second return value gives just the .auto.tfvars files, which are also
+// included in the first.
+func autoLoadFiles(dir string) (all, auto []string, err error) {
+	for _, name := range []string{"terraform.tfvars", "terraform.tfvars.json"} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			all = append(all, path)
+		} else if !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+	}
+
+	for _, pattern := range []string{"*.auto.tfvars", "*.auto.tfvars.json"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, nil, err
+		}
+		auto = append(auto, matches...)
+	}
+	sort.Strings(auto)
+
+	return append(all, auto...), auto, nil
+}
+
+// appendAutoLoadDiags finds the files in the given module directory that
+// Terraform would load automatically, for --auto-load and --require-tfvars.
+//
+// When requireTfvars is set, the result includes an error if there's no
+// terraform.tfvars or terraform.tfvars.json file, and a warning for each
+// .auto.tfvars file, since those would be used in addition to the files
+// given explicitly.
+func appendAutoLoadDiags(diags []tfconfig.Diagnostic, dir string, requireTfvars bool) ([]string, []tfconfig.Diagnostic) {
+	if dir == "" {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "No module directory",
+			Detail:   "The --auto-load and --require-tfvars options need a module directory to look for files in, so they can't be used with --module-json, --template-vars, or --declared-from-files.",
+		})
+		return nil, diags
+	}
+
+	all, auto, err := autoLoadFiles(dir)
+	if err != nil {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Can't access module directory",
+			Detail:   fmt.Sprintf("Can't read %s: %s.", dir, err),
+		})
+		return nil, diags
+	}
+	if !requireTfvars {
+		return all, diags
+	}
+
+	if len(all) == len(auto) {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Missing terraform.tfvars",
+			Detail:   fmt.Sprintf("The module directory %s must contain a terraform.tfvars or terraform.tfvars.json file when using --require-tfvars.", dir),
+		})
+	}
+	for _, path := range auto {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagWarning,
+			Summary:  "Unexpected automatic variables file",
+			Detail:   fmt.Sprintf("Terraform automatically loads %s in addition to any files given explicitly.", path),
+			Pos: &tfconfig.SourcePos{
+				Filename: path,
+				Line:     1,
+			},
+		})
+	}
+	return all, diags
+}
+
+// appendAutoOverrideDiags returns a warning for each definition in the given
+// automatically-loaded sources that is overridden by a definition in one of
+// the given explicit sources, for --no-auto-override.
+func appendAutoOverrideDiags(diags []tfconfig.Diagnostic, auto, explicit []*filtervars.Source) []tfconfig.Diagnostic {
+	overriddenBy := make(map[string]string)
+	for _, src := range explicit {
+		if src == nil {
+			continue
+		}
+		for name := range src.SyntaxBody.Attributes {
+			overriddenBy[name] = src.Name
+		}
+	}
+
+	for _, src := range auto {
+		if src == nil {
+			continue
+		}
+		names := make([]string, 0, len(src.SyntaxBody.Attributes))
+		for name := range src.SyntaxBody.Attributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			other, ok := overriddenBy[name]
+			if !ok {
+				continue
+			}
+			attr := src.SyntaxBody.Attributes[name]
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagWarning,
+				Summary:  "Automatic definition overridden",
+				Detail:   fmt.Sprintf("The value for %q from %s is overridden by the value from %s.", name, src.Name, other),
+				Pos: &tfconfig.SourcePos{
+					Filename: attr.NameRange.Filename,
+					Line:     attr.NameRange.Start.Line,
+				},
+			})
+		}
+	}
+	return diags
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// writeTerraformComparison writes a human-readable description of how the
+// values in the given result differ from those Terraform itself would use
+// if run in the given module directory with the same tfvars files given as
+// -var-file options, for --compare-terraform.
+//
+// autoLoaded and envRead report whether --auto-load and --env were set,
+// since otherwise Terraform would read sources that we didn't.
+func writeTerraformComparison(w io.Writer, reader *varFileReader, modDir string, mod *tfconfig.Module, result *filtervars.Result, autoLoaded, envRead bool) {
+	var notes []string
+	// alsoSet tracks the variables that Terraform would take from sources
+	// we didn't read, which therefore wouldn't be prompted for.
+	alsoSet := make(map[string]bool)
+
+	if !envRead {
+		envSrc, _ := envVarsSource(nil, os.Environ(), mod)
+		src, _ := reader.Parse(envSrc, envVarsSourceFilename)
+		if src != nil {
+			for _, name := range sortedAttributeNames(src) {
+				if _, declared := mod.Variables[name]; !declared {
+					continue
+				}
+				if _, defined := result.Values[name]; !defined {
+					alsoSet[name] = true
+					notes = append(notes, fmt.Sprintf("Terraform would also set %q from the %s%s environment variable, which wasn't read because --env isn't set.", name, envVarPrefix, name))
+				}
+			}
+		}
+	}
+
+	if !autoLoaded {
+		// Terraform reads these files before any -var-file options, so they
+		// make a difference only for variables that nothing else defines.
+		paths, _, _ := autoLoadFiles(modDir)
+		for _, path := range paths {
+			src, _ := reader.Read(path)
+			if src == nil {
+				continue
+			}
+			for _, name := range sortedAttributeNames(src) {
+				if _, declared := mod.Variables[name]; !declared {
+					continue
+				}
+				if _, defined := result.Values[name]; !defined && !alsoSet[name] {
+					alsoSet[name] = true
+					notes = append(notes, fmt.Sprintf("Terraform would also set %q from %s, which it loads automatically but which wasn't read because --auto-load isn't set.", name, path))
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(result.Definitions))
+	for name := range result.Definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		defs := result.Definitions[name]
+		for _, pos := range defs[:len(defs)-1] {
+			if pos.Filename == inlineVarsSourceFilename {
+				notes = append(notes, fmt.Sprintf("The value for %q from --inline-vars is overridden by the one in %s, but Terraform gives a -var option precedence over any -var-file option that comes before it on the command line.", name, defs[len(defs)-1].Filename))
+				break
+			}
+		}
+	}
+
+	undeclared := make([]string, 0, len(result.Undeclared))
+	for name := range result.Undeclared {
+		undeclared = append(undeclared, name)
+	}
+	sort.Strings(undeclared)
+	for _, name := range undeclared {
+		attr := result.Undeclared[name]
+		notes = append(notes, fmt.Sprintf("Terraform would warn that %s assigns a value to %q, which the module doesn't declare, whereas this tool just leaves it out.", attr.NameRange.Filename, name))
+	}
+
+	required := make([]string, 0, len(mod.Variables))
+	for name, v := range mod.Variables {
+		if _, defined := result.Values[name]; v.Required && !defined && !alsoSet[name] {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+	for _, name := range required {
+		notes = append(notes, fmt.Sprintf("Terraform would prompt for a value for %q, because it's required but none of the sources define it.", name))
+	}
+
+	fmt.Fprintf(w, "Compared with Terraform running in %s:\n", modDir)
+	if len(notes) == 0 {
+		fmt.Fprintf(w, "  No differences.\n")
+		return
+	}
+	for _, note := range notes {
+		fmt.Fprintf(w, "  - %s\n", note)
+	}
+}
+
+// sortedAttributeNames returns the names of the attributes defined in the
+// given source, in lexical order.
+func sortedAttributeNames(src *filtervars.Source) []string {
+	names := make([]string, 0, len(src.SyntaxBody.Attributes))
+	for name := range src.SyntaxBody.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
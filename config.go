@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+	flag "github.com/spf13/pflag"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// configFilename is the name of the optional file that can provide default
+// values for command line options.
+const configFilename = ".terraform-filter-vars.hcl"
+
+// findConfigFile returns the path to the config file that applies to the
+// given module directory, or an empty string if there isn't one. A config
+// file in the module directory takes priority over one in the current
+// working directory.
+func findConfigFile(modDir string) string {
+	var candidates []string
+	if modDir != "" {
+		candidates = append(candidates, filepath.Join(modDir, configFilename))
+	}
+	candidates = append(candidates, configFilename)
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// applyConfigFile reads the given config file and uses its attributes as
+// values for any flags that were not set explicitly on the command line.
+//
+// Attribute names are the flag names, with either dashes or underscores
+// separating words.
+func applyConfigFile(diags []tfconfig.Diagnostic, filename string, flags *flag.FlagSet) []tfconfig.Diagnostic {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Failed to read config file",
+			Detail:   fmt.Sprintf("Can't read %s: %s.", filename, err),
+		})
+	}
+
+	f, hclDiags := hclsyntax.ParseConfig(src, filename, hcl.Pos{Line: 1, Column: 1})
+	diags = appendHCLDiags(diags, hclDiags)
+	if hclDiags.HasErrors() {
+		return diags
+	}
+	attrs, hclDiags := f.Body.JustAttributes()
+	diags = appendHCLDiags(diags, hclDiags)
+	if hclDiags.HasErrors() {
+		return diags
+	}
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		attr := attrs[name]
+		pos := &tfconfig.SourcePos{
+			Filename: attr.NameRange.Filename,
+			Line:     attr.NameRange.Start.Line,
+		}
+		flagName := strings.Replace(name, "_", "-", -1)
+		fl := flags.Lookup(flagName)
+		if fl == nil || !configurableFlag(flagName) {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Unsupported config file setting",
+				Detail:   fmt.Sprintf("There is no option named %q that can be set in a config file.", name),
+				Pos:      pos,
+			})
+			continue
+		}
+		if fl.Changed {
+			continue // command line takes precedence
+		}
+
+		val, hclDiags := attr.Expr.Value(nil)
+		diags = appendHCLDiags(diags, hclDiags)
+		if hclDiags.HasErrors() {
+			continue
+		}
+
+		// Options that can be repeated on the command line can be set to
+		// a list in the config file, while all others take a single value.
+		vals := []cty.Value{val}
+		if ty := val.Type(); ty.IsListType() || ty.IsTupleType() || ty.IsSetType() {
+			vals = vals[:0]
+			for it := val.ElementIterator(); it.Next(); {
+				_, v := it.Element()
+				vals = append(vals, v)
+			}
+		}
+
+		for _, v := range vals {
+			str, err := configValueString(v)
+			if err == nil {
+				err = flags.Set(flagName, str)
+			}
+			if err != nil {
+				diags = append(diags, tfconfig.Diagnostic{
+					Severity: tfconfig.DiagError,
+					Summary:  "Invalid config file setting",
+					Detail:   fmt.Sprintf("Invalid value for %q: %s.", name, err),
+					Pos:      pos,
+				})
+				break
+			}
+		}
+	}
+
+	return diags
+}
+
+// configurableFlag returns true if the flag with the given name may be set
+// in a config file. Options that decide which module the config file is
+// loaded relative to can't be, because that would be circular.
+func configurableFlag(name string) bool {
+	switch name {
+	case "version", "module-json":
+		return false
+	default:
+		return true
+	}
+}
+
+// configValueString returns the string representation of a primitive value
+// from a config file, as it would be written on the command line.
+func configValueString(val cty.Value) (string, error) {
+	if val.IsNull() {
+		return "", fmt.Errorf("must not be null")
+	}
+	strVal, err := convert.Convert(val, cty.String)
+	if err != nil {
+		return "", fmt.Errorf("must be a string, number, or bool")
+	}
+	return strVal.AsString(), nil
+}
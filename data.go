@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+)
+
+// dataRefPattern matches the ${data.key} references that can be expanded
+// from a --data file. A key may be a dot-separated path into nested objects.
+var dataRefPattern = regexp.MustCompile(`\$\{data\.([A-Za-z0-9_\-]+(?:\.[A-Za-z0-9_\-]+)*)\}`)
+
+// loadDataFile reads the JSON object in the given file, for use with
+// expandDataRefs.
+func loadDataFile(filename string) (map[string]interface{}, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Failed to read data file",
+			Detail:   fmt.Sprintf("Can't read %s: %s.", filename, err),
+		})
+		return nil, diags
+	}
+
+	var data map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber() // preserve the number exactly as written
+	if err := dec.Decode(&data); err != nil {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Invalid data file",
+			Detail:   fmt.Sprintf("Can't decode %s: %s. The data file must contain a JSON object.", filename, err),
+		})
+		return nil, diags
+	}
+
+	return data, diags
+}
+
+// expandDataRefs replaces each ${data.key} reference in the given raw source
+// with the corresponding value from the data file. This happens before
+// parsing, so a reference typically appears inside a quoted string.
+//
+// A reference written as $${data.key} is the HCL escape for a literal
+// "${", and so is left unchanged.
+//
+// Each value is escaped as for a quoted string, as described for
+// escapeQuotedString, so that it can't end the string early or introduce
+// template sequences of its own.
+func expandDataRefs(src []byte, filename string, data map[string]interface{}) ([]byte, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+	var buf bytes.Buffer
+	last := 0
+	for _, loc := range dataRefPattern.FindAllSubmatchIndex(src, -1) {
+		start, end := loc[0], loc[1]
+		if start > 0 && src[start-1] == '$' {
+			continue
+		}
+
+		key := string(src[loc[2]:loc[3]])
+		str, err := dataValueString(data, key)
+		if err != nil {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Invalid data reference",
+				Detail:   fmt.Sprintf("Can't expand data.%s: %s.", key, err),
+				Pos: &tfconfig.SourcePos{
+					Filename: filename,
+					Line:     bytes.Count(src[:start], []byte{'\n'}) + 1,
+				},
+			})
+			continue
+		}
+
+		buf.Write(src[last:start])
+		buf.WriteString(escapeQuotedString(str))
+		last = end
+	}
+	buf.Write(src[last:])
+	return buf.Bytes(), diags
+}
+
+// quotedStringEscaper escapes the characters that have special meaning
+// inside an HCL quoted string.
+var quotedStringEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"${", "$${",
+	"%{", "%%{",
+)
+
+// escapeQuotedString returns the given string escaped so that, when written
+// between the quotes of an HCL quoted string, it represents itself exactly.
+func escapeQuotedString(s string) string {
+	return quotedStringEscaper.Replace(s)
+}
+
+// dataValueString finds the value at the given dot-separated path in the
+// data and returns it as a string suitable for inserting into source code.
+func dataValueString(data map[string]interface{}, key string) (string, error) {
+	var current interface{} = data
+	for _, step := range strings.Split(key, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("%q is not an object", step)
+		}
+		current, ok = obj[step]
+		if !ok {
+			return "", fmt.Errorf("no key %q in the data file", step)
+		}
+	}
+
+	switch v := current.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprintf("%t", v), nil
+	default:
+		return "", fmt.Errorf("only strings, numbers, and bools can be expanded")
+	}
+}
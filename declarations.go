@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// showDeclarationsDryRun reports to stderr what buildDeclarationsFile and
+// writeOutputFile would produce for the same arguments, without actually
+// writing anything, for --emit-declarations with --dry-run.
+func showDeclarationsDryRun(path string, attrs map[string]*hclsyntax.Attribute) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dest := path
+	if dest == "-" {
+		dest = "stdout"
+	}
+	fmt.Fprintf(os.Stderr, "Would write %d variable declarations to %s", len(names), dest)
+	if len(names) > 0 {
+		fmt.Fprintf(os.Stderr, ": %s", strings.Join(names, ", "))
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// buildDeclarationsFile produces a new file containing a variable block for
+// each of the given attributes, with a type constraint inferred from the
+// attribute's value where possible.
+//
+// This is the reverse of the usual filtering operation: rather than
+// discarding values that have no declaration, it declares them.
+func buildDeclarationsFile(attrs map[string]*hclsyntax.Attribute) *hclwrite.File {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	f := hclwrite.NewEmptyFile()
+	body := f.Body()
+	for i, name := range names {
+		if i > 0 {
+			body.AppendNewline()
+		}
+		block := body.AppendNewBlock("variable", []string{name})
+
+		// Values in tfvars files can't contain references, so any errors
+		// here indicate that the value isn't valid at all and so we can't
+		// infer a type. We'll just leave the variable unconstrained in
+		// that case, and let Terraform report the problem later.
+		val, diags := attrs[name].Expr.Value(nil)
+		if diags.HasErrors() || val.IsNull() {
+			continue
+		}
+		toks := hclwrite.Tokens{
+			identToken("type"),
+			{Type: hclsyntax.TokenEqual, Bytes: []byte{'='}},
+		}
+		toks = append(toks, typeExprTokens(val.Type())...)
+		toks = append(toks, &hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte{'\n'}})
+		block.Body().AppendUnstructuredTokens(toks)
+	}
+
+	// The tokens we generated above have no spacing information, so we'll
+	// round-trip through the formatter to get conventional layout.
+	formatted, _ := hclwrite.ParseConfig(hclwrite.Format(f.Bytes()), "", hcl.Pos{Line: 1, Column: 1})
+	return formatted
+}
+
+// typeExprTokens returns tokens for a type constraint expression that would
+// accept values of the given type, preferring the collection types that
+// module authors typically use over the structural types that HCL literal
+// syntax produces.
+func typeExprTokens(ty cty.Type) hclwrite.Tokens {
+	switch {
+	case ty == cty.String:
+		return hclwrite.Tokens{identToken("string")}
+	case ty == cty.Number:
+		return hclwrite.Tokens{identToken("number")}
+	case ty == cty.Bool:
+		return hclwrite.Tokens{identToken("bool")}
+	case ty.IsTupleType():
+		etys := ty.TupleElementTypes()
+		if ety, ok := uniformType(etys); ok {
+			return typeCallTokens("list", typeExprTokens(ety))
+		}
+		var arg hclwrite.Tokens
+		arg = append(arg, &hclwrite.Token{Type: hclsyntax.TokenOBrack, Bytes: []byte{'['}})
+		for i, ety := range etys {
+			if i > 0 {
+				arg = append(arg, &hclwrite.Token{Type: hclsyntax.TokenComma, Bytes: []byte{','}})
+			}
+			arg = append(arg, typeExprTokens(ety)...)
+		}
+		arg = append(arg, &hclwrite.Token{Type: hclsyntax.TokenCBrack, Bytes: []byte{']'}})
+		return typeCallTokens("tuple", arg)
+	case ty.IsObjectType():
+		atys := ty.AttributeTypes()
+		names := make([]string, 0, len(atys))
+		etys := make([]cty.Type, 0, len(atys))
+		for name := range atys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			etys = append(etys, atys[name])
+		}
+		if ety, ok := uniformType(etys); ok {
+			return typeCallTokens("map", typeExprTokens(ety))
+		}
+		var arg hclwrite.Tokens
+		arg = append(arg, &hclwrite.Token{Type: hclsyntax.TokenOBrace, Bytes: []byte{'{'}})
+		for i, name := range names {
+			if i > 0 {
+				arg = append(arg, &hclwrite.Token{Type: hclsyntax.TokenComma, Bytes: []byte{','}})
+			}
+			arg = append(arg, identToken(name))
+			arg = append(arg, &hclwrite.Token{Type: hclsyntax.TokenEqual, Bytes: []byte{'='}})
+			arg = append(arg, typeExprTokens(atys[name])...)
+		}
+		arg = append(arg, &hclwrite.Token{Type: hclsyntax.TokenCBrace, Bytes: []byte{'}'}})
+		return typeCallTokens("object", arg)
+	default:
+		return hclwrite.Tokens{identToken("any")}
+	}
+}
+
+// uniformType returns the single type shared by all of the given types, if
+// there is one. An empty set of types is treated as uniformly "any".
+func uniformType(tys []cty.Type) (cty.Type, bool) {
+	if len(tys) == 0 {
+		return cty.DynamicPseudoType, true
+	}
+	for _, ty := range tys[1:] {
+		if !ty.Equals(tys[0]) {
+			return cty.NilType, false
+		}
+	}
+	return tys[0], true
+}
+
+func typeCallTokens(name string, arg hclwrite.Tokens) hclwrite.Tokens {
+	toks := hclwrite.Tokens{
+		identToken(name),
+		{Type: hclsyntax.TokenOParen, Bytes: []byte{'('}},
+	}
+	toks = append(toks, arg...)
+	toks = append(toks, &hclwrite.Token{Type: hclsyntax.TokenCParen, Bytes: []byte{')'}})
+	return toks
+}
+
+func identToken(name string) *hclwrite.Token {
+	return &hclwrite.Token{Type: hclsyntax.TokenIdent, Bytes: []byte(name)}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// readDenyValues reads the file of forbidden values for --deny-values.
+//
+// Each non-empty line of the file that doesn't start with # is a single
+// literal value written in HCL native syntax, such as "10.0.0.0/8", 22, or
+// true. Only primitive values are allowed.
+func readDenyValues(diags []tfconfig.Diagnostic, filename string) ([]cty.Value, []tfconfig.Diagnostic) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Failed to read deny list",
+			Detail:   fmt.Sprintf("Can't read %s: %s.", filename, err),
+		})
+		return nil, diags
+	}
+
+	var ret []cty.Value
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		pos := &tfconfig.SourcePos{Filename: filename, Line: line}
+
+		expr, hclDiags := hclsyntax.ParseExpression([]byte(text), filename, hcl.Pos{Line: line, Column: 1})
+		if !hclDiags.HasErrors() {
+			var val cty.Value
+			val, hclDiags = expr.Value(nil)
+			if !hclDiags.HasErrors() {
+				if !val.Type().IsPrimitiveType() || val.IsNull() {
+					diags = append(diags, tfconfig.Diagnostic{
+						Severity: tfconfig.DiagError,
+						Summary:  "Invalid deny list entry",
+						Detail:   "Each entry in a deny list must be a single string, number, or bool value.",
+						Pos:      pos,
+					})
+					continue
+				}
+				ret = append(ret, val)
+				continue
+			}
+		}
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Invalid deny list entry",
+			Detail:   fmt.Sprintf("Can't parse %s as a literal value: %s.", text, hclDiags[0].Summary),
+			Pos:      pos,
+		})
+	}
+	return ret, diags
+}
+
+// appendDeniedValueDiags returns an error for each of the given values that
+// is, or contains, one of the forbidden values listed in the given file, for
+// --deny-values.
+func appendDeniedValueDiags(diags []tfconfig.Diagnostic, filename string, names []string, values map[string]*hclsyntax.Attribute) []tfconfig.Diagnostic {
+	deny, diags := readDenyValues(diags, filename)
+	if len(deny) == 0 {
+		return diags
+	}
+
+	for _, name := range names {
+		attr, ok := values[name]
+		if !ok {
+			continue
+		}
+		val, hclDiags := attr.Expr.Value(nil)
+		if hclDiags.HasErrors() {
+			continue
+		}
+
+		var found cty.Value
+		cty.Walk(val, func(path cty.Path, v cty.Value) (bool, error) {
+			if found != cty.NilVal {
+				return false, nil
+			}
+			if !v.IsKnown() || v.IsNull() || !v.Type().IsPrimitiveType() {
+				return true, nil
+			}
+			for _, denied := range deny {
+				if v.Type().Equals(denied.Type()) && v.Equals(denied).True() {
+					found = denied
+					return false, nil
+				}
+			}
+			return true, nil
+		})
+		if found == cty.NilVal {
+			continue
+		}
+
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Forbidden value",
+			Detail:   fmt.Sprintf("The value for %q includes %s, which is in the deny list.", name, formatDeniedValue(found)),
+			Pos: &tfconfig.SourcePos{
+				Filename: attr.SrcRange.Filename,
+				Line:     attr.SrcRange.Start.Line,
+			},
+		})
+	}
+	return diags
+}
+
+// formatDeniedValue returns the given primitive value as it would be
+// written in HCL native syntax, for use in messages.
+func formatDeniedValue(val cty.Value) string {
+	switch val.Type() {
+	case cty.String:
+		return fmt.Sprintf("%q", val.AsString())
+	case cty.Bool:
+		return fmt.Sprintf("%t", val.True())
+	default:
+		return val.AsBigFloat().Text('f', -1)
+	}
+}
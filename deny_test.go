@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestCLIDenyValues(t *testing.T) {
+	files := withTestModule(map[string]string{
+		"a.tfvars": "region = \"us-east-1\"\nname = [\"web\", 8080, true]\n",
+		"deny.txt": "# Regions we don't deploy to.\n\"eu-west-1\"\n\n22\n",
+	})
+
+	runCLITests(t, map[string]cliTest{
+		"no forbidden values": {
+			Files:      files,
+			Args:       []string{"--deny-values", "deny.txt", "mod", "a.tfvars"},
+			WantStdout: "name   = [\"web\", 8080, true]\nregion = \"us-east-1\"\n",
+		},
+		"forbidden value in a later file": {
+			Files: withTestModule(map[string]string{
+				"a.tfvars": "region = \"us-east-1\"\n",
+				"b.tfvars": "region = \"eu-west-1\"\n",
+				"deny.txt": "\"eu-west-1\"\n",
+			}),
+			Args:       []string{"--deny-values", "deny.txt", "mod", "a.tfvars", "b.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"Error:  (b.tfvars:1) Forbidden value; The value for \"region\" includes \"eu-west-1\", which is in the deny list."},
+		},
+		"forbidden value nested in a collection": {
+			Files: withTestModule(map[string]string{
+				"a.tfvars": "name = [\"web\", 22]\n",
+				"deny.txt": "22\n",
+			}),
+			Args:       []string{"--deny-values", "deny.txt", "mod", "a.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"The value for \"name\" includes 22, which is in the deny list."},
+		},
+		"same value of another type": {
+			Files: withTestModule(map[string]string{
+				"a.tfvars": "name = \"22\"\n",
+				"deny.txt": "22\n",
+			}),
+			Args:       []string{"--deny-values", "deny.txt", "mod", "a.tfvars"},
+			WantStdout: "name = \"22\"\n",
+		},
+		"collection in deny list": {
+			Files: withTestModule(map[string]string{
+				"a.tfvars": "name = \"web\"\n",
+				"deny.txt": "[1, 2]\n",
+			}),
+			Args:       []string{"--deny-values", "deny.txt", "mod", "a.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"Error:  (deny.txt:1) Invalid deny list entry; Each entry in a deny list must be a single string, number, or bool value."},
+		},
+		"missing deny list": {
+			Files:      files,
+			Args:       []string{"--deny-values", "missing.txt", "mod", "a.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"Error: Failed to read deny list; Can't read missing.txt:"},
+		},
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// envVarPrefix is the prefix Terraform uses for environment variables that
+// set root module variables.
+const envVarPrefix = "TF_VAR_"
+
+// envVarsSourceFilename is the pseudo-filename used in diagnostics about
+// values that came from environment variables.
+const envVarsSourceFilename = "<environment>"
+
+// envVarsSource produces tfvars source code defining each of the variables
+// declared in the given module that is set by a TF_VAR_ environment variable
+// in the given environment, which is in the same format as os.Environ
+// returns. As with Terraform itself, other TF_VAR_ environment variables are
+// ignored.
+//
+// As with Terraform itself, the value of a variable whose type constraint
+// is a primitive type (or which has no type constraint) is taken literally
+// as a string, while any other value is parsed as an HCL expression. A value
+// that isn't a valid expression is skipped with a warning, so that it
+// doesn't prevent the other variables from being used.
+func envVarsSource(diags []tfconfig.Diagnostic, environ []string, mod *tfconfig.Module) ([]byte, []tfconfig.Diagnostic) {
+	vals := make(map[string]string)
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envVarPrefix) {
+			continue
+		}
+		eq := strings.IndexByte(kv, '=')
+		if eq == -1 {
+			continue
+		}
+		name := kv[len(envVarPrefix):eq]
+		if _, declared := mod.Variables[name]; !declared {
+			continue
+		}
+		vals[name] = kv[eq+1:]
+	}
+
+	names := make([]string, 0, len(vals))
+	for name := range vals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		raw := vals[name]
+		if envVarIsLiteral(mod.Variables[name]) {
+			raw = string(hclwrite.TokensForValue(cty.StringVal(raw)).Bytes())
+		} else if _, hclDiags := hclsyntax.ParseExpression([]byte(raw), envVarPrefix+name, hcl.Pos{Line: 1, Column: 1}); hclDiags.HasErrors() {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagWarning,
+				Summary:  "Invalid environment variable value",
+				Detail:   fmt.Sprintf("The value of %s%s isn't a valid expression for a variable of type %s, so it's ignored: %s.", envVarPrefix, name, mod.Variables[name].Type, strings.TrimSuffix(hclDiags[0].Detail, ".")),
+			})
+			continue
+		}
+		buf.WriteString(name)
+		buf.WriteString(" = ")
+		buf.WriteString(raw)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), diags
+}
+
+// envVarIsLiteral returns true if an environment variable value for the
+// given variable should be taken literally as a string.
+func envVarIsLiteral(v *tfconfig.Variable) bool {
+	if v == nil {
+		return true
+	}
+	ty, err := variableTypeConstraint(v)
+	if err != nil {
+		return true
+	}
+	return ty.IsPrimitiveType() || ty == cty.DynamicPseudoType
+}
+
+// envExpansionVariables returns the value of the "env" object for --expand-env,
+// containing each environment variable referred to as env.NAME by the values
+// for the variables declared in the given module.
+//
+// A reference to an environment variable that isn't set is an error unless
+// hasDefault is set, in which case the given default value is used instead.
+func envExpansionVariables(diags []tfconfig.Diagnostic, sources []*filtervars.Source, mod *tfconfig.Module, environ []string, defaultVal string, hasDefault bool) (map[string]cty.Value, []tfconfig.Diagnostic) {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if eq := strings.IndexByte(kv, '='); eq > 0 {
+			env[kv[:eq]] = kv[eq+1:]
+		}
+	}
+
+	vals := make(map[string]cty.Value)
+	for _, src := range sources {
+		if src == nil {
+			continue
+		}
+		for _, name := range src.AttributeNames() {
+			if _, declared := mod.Variables[name]; !declared {
+				continue
+			}
+			for _, traversal := range src.SyntaxBody.Attributes[name].Expr.Variables() {
+				if traversal.RootName() != "env" || len(traversal) < 2 {
+					continue
+				}
+				step, ok := traversal[1].(hcl.TraverseAttr)
+				if !ok {
+					continue
+				}
+				if _, done := vals[step.Name]; done {
+					continue
+				}
+				v, set := env[step.Name]
+				if !set && !hasDefault {
+					rng := traversal.SourceRange()
+					diags = append(diags, tfconfig.Diagnostic{
+						Severity: tfconfig.DiagError,
+						Summary:  "Unset environment variable",
+						Detail:   fmt.Sprintf("The value for %q refers to env.%s, but that environment variable is not set. Use --expand-env-default to give a value for unset environment variables.", name, step.Name),
+						Pos: &tfconfig.SourcePos{
+							Filename: rng.Filename,
+							Line:     rng.Start.Line,
+						},
+					})
+					continue
+				}
+				if !set {
+					v = defaultVal
+				}
+				vals[step.Name] = cty.StringVal(v)
+			}
+		}
+	}
+
+	return map[string]cty.Value{
+		"env": cty.ObjectVal(vals),
+	}, diags
+}
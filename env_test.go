@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestCLIEnv(t *testing.T) {
+	files := map[string]string{
+		"mod/variables.tf": `variable "from_env" {}
+variable "from_first" {}
+variable "from_second" {}
+variable "names" {
+  type = list(string)
+}
+`,
+		"first.tfvars":  "from_first = \"first\"\nfrom_second = \"first\"\n",
+		"second.tfvars": "from_second = \"second\"\n",
+	}
+	env := []string{
+		"TF_VAR_from_env=env",
+		"TF_VAR_from_first=env",
+		"TF_VAR_from_second=env",
+	}
+
+	runCLITests(t, map[string]cliTest{
+		"lowest precedence": {
+			Files: files,
+			Env:   env,
+			Args:  []string{"--env", "mod", "first.tfvars", "second.tfvars"},
+			WantStdout: `from_env    = "env"
+from_first  = "first"
+from_second = "second"
+`,
+		},
+		"not without --env": {
+			Files:      files,
+			Env:        env,
+			Args:       []string{"mod", "second.tfvars"},
+			WantStdout: "from_second = \"second\"\n",
+		},
+		"undeclared and invalid names": {
+			Files:      files,
+			Env:        []string{"TF_VAR_1bad=x", "TF_VAR_extra=y", "TF_VAR_from_env=env", "PATH_ISH=z"},
+			Args:       []string{"--env", "mod"},
+			WantStdout: "from_env = \"env\"\n",
+		},
+		"complex value": {
+			Files:      files,
+			Env:        []string{`TF_VAR_names=["a", "b"]`},
+			Args:       []string{"--env", "mod"},
+			WantStdout: "names = [\"a\", \"b\"]\n",
+		},
+		"invalid complex value": {
+			Files:      files,
+			Env:        []string{`TF_VAR_names=["a"`, "TF_VAR_from_env=env"},
+			Args:       []string{"--env", "mod"},
+			WantStdout: "from_env = \"env\"\n",
+			WantStderr: []string{"Warning: Invalid environment variable value; The value of TF_VAR_names isn't a valid expression for a variable of type list(string), so it's ignored: "},
+		},
+		"with split by file": {
+			Files:      files,
+			Env:        env,
+			Args:       []string{"--env", "--split-by-file", "--out-dir", "out", "mod", "first.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"Error: Conflicting input options; The --env option can't be used with --split-by-file"},
+		},
+	})
+}
@@ -1,3 +1,2 @@
 variable "foo" {}
 variable "bar" {}
-variable "baz" {}
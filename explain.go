@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// writeExplanation writes a human-readable description of everything we
+// know about the variable with the given name, for --explain: its
+// declaration, each of its definitions in the sources, and what became of
+// the winning value.
+//
+// Values of sensitive variables are redacted.
+func writeExplanation(w io.Writer, name string, mod *tfconfig.Module, sources []*filtervars.Source, result *filtervars.Result, names []string) {
+	v := mod.Variables[name]
+	fmt.Fprintf(w, "Variable %q\n", name)
+	if v == nil {
+		fmt.Fprintf(w, "  Not declared in the module.\n")
+	} else {
+		typeStr := v.Type
+		if typeStr == "" {
+			typeStr = "any"
+		}
+		if v.Pos.Filename != "" {
+			fmt.Fprintf(w, "  Declared at %s:%d\n", v.Pos.Filename, v.Pos.Line)
+		}
+		fmt.Fprintf(w, "  Type:        %s\n", typeStr)
+		if v.Required {
+			fmt.Fprintf(w, "  Default:     (none; the variable is required)\n")
+		} else {
+			fmt.Fprintf(w, "  Default:     %s\n", explainDefault(v))
+		}
+		if v.Description != "" {
+			fmt.Fprintf(w, "  Description: %s\n", v.Description)
+		}
+		fmt.Fprintf(w, "  Sensitive:   %t\n", v.Sensitive)
+	}
+	sensitive := v != nil && v.Sensitive
+
+	fmt.Fprintf(w, "\nDefinitions, in order of precedence from lowest to highest:\n")
+	found := false
+	for _, src := range sources {
+		if src == nil {
+			continue
+		}
+		attr, ok := src.SyntaxBody.Attributes[name]
+		if !ok {
+			continue
+		}
+		found = true
+		valStr := "(sensitive)"
+		if !sensitive {
+			valStr = explainValue(src, name)
+		}
+		fmt.Fprintf(w, "  %s:%d: %s\n", attr.NameRange.Filename, attr.NameRange.Start.Line, valStr)
+	}
+	if !found {
+		fmt.Fprintf(w, "  (none)\n")
+	}
+
+	fmt.Fprintf(w, "\n")
+	reason := ""
+	for _, dropped := range result.Dropped {
+		if dropped.Name == name {
+			reason = dropped.Reason
+		}
+	}
+	_, hasValue := result.Attrs[name]
+	included := false
+	for _, n := range names {
+		if n == name {
+			included = hasValue
+			break
+		}
+	}
+	switch {
+	case included:
+		valStr := "(sensitive)"
+		if !sensitive {
+			valStr = strings.TrimSpace(string(result.Attrs[name].Expr().BuildTokens(nil).Bytes()))
+		}
+		fmt.Fprintf(w, "Included in the output as: %s\n", valStr)
+	case reason != "":
+		fmt.Fprintf(w, "Not included in the output, because %s.\n", reason)
+	case !found:
+		fmt.Fprintf(w, "Not included in the output, because no source defines it.\n")
+	default:
+		fmt.Fprintf(w, "Not included in the output.\n")
+	}
+}
+
+// explainValue returns the source code of the value the given source
+// defines for the given variable.
+func explainValue(src *filtervars.Source, name string) string {
+	attr := src.Body.GetAttribute(name)
+	if attr == nil {
+		return "(unknown)"
+	}
+	return strings.TrimSpace(string(attr.Expr().BuildTokens(nil).Bytes()))
+}
+
+// explainDefault returns a representation of the default value of the given
+// variable, which tfconfig gives only as a JSON-like approximation.
+func explainDefault(v *tfconfig.Variable) string {
+	if v.Sensitive {
+		return "(sensitive)"
+	}
+	if v.Default == nil {
+		return "null"
+	}
+	src, err := json.Marshal(v.Default)
+	if err != nil {
+		return fmt.Sprintf("%v", v.Default)
+	}
+	return string(src)
+}
@@ -0,0 +1,500 @@
+// Package filtervars implements the core of terraform-filter-vars: merging
+// variable values from a sequence of tfvars sources and keeping only those
+// for variables declared in a particular module.
+//
+// The terraform-filter-vars command is a thin wrapper around this package
+// that deals with reading files and writing the result, so applications
+// that already have parsed tfvars content can use this package directly.
+package filtervars
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Source is a single source of variable values, such as a tfvars file.
+type Source struct {
+	// Name identifies the source, and is typically the path of the file
+	// the source was read from.
+	Name string
+
+	// Body is the content of the source for inclusion in the output.
+	Body *hclwrite.Body
+
+	// SyntaxBody is the same content parsed with hclsyntax, which retains
+	// source positions and allows evaluating the values.
+	SyntaxBody *hclsyntax.Body
+
+	// FromJSON is set if the source was converted from JSON syntax, in
+	// which case Body doesn't preserve the original layout.
+	FromJSON bool
+}
+
+// AttributeNames returns the names of the attributes defined in the source,
+// in the order they appear.
+func (s *Source) AttributeNames() []string {
+	names := make([]string, 0, len(s.SyntaxBody.Attributes))
+	for name := range s.SyntaxBody.Attributes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return s.SyntaxBody.Attributes[names[i]].SrcRange.Start.Byte < s.SyntaxBody.Attributes[names[j]].SrcRange.Start.Byte
+	})
+	return names
+}
+
+// TransformFunc is the signature of a function that can transform or reject
+// the value of a variable before it is included in the result.
+//
+// The function returns the value to use instead, which may be the given value
+// unchanged. If it returns cty.NilVal, or a diagnostic with error severity,
+// the variable is rejected and won't appear in the result. Any diagnostic
+// returned is included in the diagnostics from Filter, with the position of
+// the value if it doesn't have a position of its own.
+type TransformFunc func(name string, val cty.Value) (cty.Value, *tfconfig.Diagnostic)
+
+// Filterer selects values for the variables declared in a module.
+type Filterer struct {
+	// Module is the module whose declared variables are to be kept.
+	Module *tfconfig.Module
+
+	// Transform, if non-nil, is called with the merged value of each kept
+	// variable, in name order.
+	Transform TransformFunc
+
+	// Variables, if non-nil, are values that the sources may refer to by
+	// name, like env.FOO, even though Terraform wouldn't allow any
+	// references in tfvars files. Each value that refers to any of them is
+	// evaluated and replaced by its result before Transform is called.
+	Variables map[string]cty.Value
+
+	// AllowUndeclared is the names of undeclared variables whose values are
+	// expected in the sources, such as settings that are used by other
+	// tools. They're still excluded from the result and included in
+	// Undeclared, but aren't included in Dropped.
+	AllowUndeclared map[string]bool
+}
+
+// Result is the result of filtering a sequence of sources.
+type Result struct {
+	// Names is the names of all of the variables declared in the module,
+	// in lexical order. Not all of them necessarily have values.
+	Names []string
+
+	// Attrs is the winning definition of each variable that has a value,
+	// ready to be included in the output.
+	Attrs map[string]*hclwrite.Attribute
+
+	// Values is the same definitions as Attrs, in hclsyntax form.
+	Values map[string]*hclsyntax.Attribute
+
+	// SourceAttrs has one element per source given to Filter, containing
+	// the definitions from only that source. These aren't affected by
+	// the Filterer's Transform function.
+	SourceAttrs []map[string]*hclwrite.Attribute
+
+	// Definitions records where each of the declared variables is defined
+	// in the sources, in the order the sources were given. The last
+	// position is therefore the definition that "won". Unlike Attrs, this
+	// isn't affected by the Filterer's Transform function.
+	Definitions map[string][]Position
+
+	// Undeclared is the final definition for each variable that appeared
+	// in the sources but isn't declared in the module.
+	Undeclared map[string]*hclsyntax.Attribute
+
+	// Dropped is all of the values that were defined in the sources but
+	// won't be included in the result, except those that were just
+	// overridden by a later source.
+	Dropped []DroppedValue
+}
+
+// Each calls the given function with the winning definition of each variable
+// that has a value, in the same order as Names, so that a caller can
+// serialize the result incrementally rather than building a whole file in
+// memory. If the function returns an error then Each stops immediately and
+// returns that error.
+func (r *Result) Each(fn func(name string, attr *hclwrite.Attribute) error) error {
+	for _, name := range r.Names {
+		attr, ok := r.Attrs[name]
+		if !ok {
+			continue
+		}
+		if err := fn(name, attr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Position is the location of the start of a variable definition in a
+// source.
+type Position struct {
+	Filename string `json:"filename"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+}
+
+// DroppedValue records a value that was defined in a source but that won't
+// be included in the result.
+type DroppedValue struct {
+	Name string
+
+	// Reason is a phrase explaining why the value was dropped, which can
+	// follow "because" in a sentence.
+	Reason string
+
+	Pos tfconfig.SourcePos
+}
+
+// NewDroppedValue returns a DroppedValue recording that the given definition
+// was dropped for the given reason, for callers that remove values from a
+// Result after filtering.
+func NewDroppedValue(attr *hclsyntax.Attribute, reason string) DroppedValue {
+	return DroppedValue{
+		Name:   attr.Name,
+		Reason: reason,
+		Pos: tfconfig.SourcePos{
+			Filename: attr.NameRange.Filename,
+			Line:     attr.NameRange.Start.Line,
+		},
+	}
+}
+
+// DiagnosticSink receives diagnostics as they are produced, such as to
+// report them incrementally from a long-running process.
+type DiagnosticSink interface {
+	Append(diag tfconfig.Diagnostic)
+}
+
+// DiagnosticSlice is a DiagnosticSink that just collects the diagnostics it
+// receives.
+type DiagnosticSlice []tfconfig.Diagnostic
+
+// Append implements DiagnosticSink.
+func (s *DiagnosticSlice) Append(diag tfconfig.Diagnostic) {
+	*s = append(*s, diag)
+}
+
+// Filter merges the given sources and keeps only the values for variables
+// declared in the module.
+//
+// The sources are merged in the given order and so if more than one source
+// defines the same variable the last one "wins", which is consistent with
+// Terraform's own interpretation of multiple -var-file arguments. A nil
+// source is ignored, so callers can leave placeholders for sources that
+// failed to load.
+func (f *Filterer) Filter(sources []*Source) (*Result, []tfconfig.Diagnostic) {
+	var diags DiagnosticSlice
+	ret := f.FilterTo(sources, &diags)
+	return ret, diags
+}
+
+// FilterTo is like Filter, but delivers each diagnostic to the given sink as
+// soon as it's produced rather than returning them all at the end.
+func (f *Filterer) FilterTo(sources []*Source, sink DiagnosticSink) *Result {
+	names := make([]string, 0, len(f.Module.Variables))
+	for name := range f.Module.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := &Result{
+		Names:       names,
+		Attrs:       make(map[string]*hclwrite.Attribute, len(names)),
+		Values:      make(map[string]*hclsyntax.Attribute, len(names)),
+		SourceAttrs: make([]map[string]*hclwrite.Attribute, len(sources)),
+		Definitions: make(map[string][]Position, len(names)),
+		Undeclared:  make(map[string]*hclsyntax.Attribute),
+	}
+
+	for i, src := range sources {
+		ret.SourceAttrs[i] = make(map[string]*hclwrite.Attribute)
+		if src == nil {
+			continue
+		}
+
+		bodyAttrs := src.Body.Attributes()
+		for _, name := range src.AttributeNames() {
+			attr := bodyAttrs[name]
+			syntaxAttr := src.SyntaxBody.Attributes[name]
+			if _, exists := f.Module.Variables[name]; !exists {
+				ret.Undeclared[name] = syntaxAttr
+				if !f.AllowUndeclared[name] {
+					ret.Dropped = append(ret.Dropped, NewDroppedValue(syntaxAttr, "it is not declared"))
+				}
+				continue
+			}
+			ret.Attrs[name] = attr
+			ret.Values[name] = syntaxAttr
+			ret.SourceAttrs[i][name] = attr
+			ret.Definitions[name] = append(ret.Definitions[name], Position{
+				Filename: syntaxAttr.NameRange.Filename,
+				Line:     syntaxAttr.NameRange.Start.Line,
+				Column:   syntaxAttr.NameRange.Start.Column,
+			})
+		}
+	}
+
+	f.checkReferences(ret, sink)
+	if f.Variables != nil {
+		f.evaluate(ret, sink)
+	}
+	if f.Transform != nil {
+		f.transform(ret, sink)
+	}
+
+	return ret
+}
+
+// FilterBodies is a convenience wrapper around Filterer.Filter for callers
+// that already have the content of each source as an hclwrite body, such as
+// from a file constructed in memory. The bodies are used directly in the
+// result, so the caller should not modify them afterwards.
+func FilterBodies(mod *tfconfig.Module, bodies []*hclwrite.Body) (*Result, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	sources := make([]*Source, len(bodies))
+	for i, body := range bodies {
+		var moreDiags []tfconfig.Diagnostic
+		sources[i], moreDiags = SourceFromBody(fmt.Sprintf("<body %d>", i), body)
+		diags = append(diags, moreDiags...)
+	}
+
+	f := &Filterer{Module: mod}
+	result, moreDiags := f.Filter(sources)
+	diags = append(diags, moreDiags...)
+	return result, diags
+}
+
+// SourceFromBody returns a source for the given hclwrite body, using the
+// given name for any source positions.
+//
+// The hclsyntax form of the body is derived from its tokens, so the
+// positions refer to locations in the body as it would be written out,
+// rather than to wherever its content originally came from. If those tokens
+// aren't valid then the result is nil, and the diagnostics explain why.
+func SourceFromBody(name string, body *hclwrite.Body) (*Source, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	src := body.BuildTokens(nil).Bytes()
+	f, hclDiags := hclsyntax.ParseConfig(src, name, hcl.Pos{Line: 1, Column: 1})
+	for _, hclDiag := range hclDiags {
+		diags = append(diags, diagnosticFromHCL(hclDiag))
+	}
+	if hclDiags.HasErrors() {
+		return nil, diags
+	}
+
+	return &Source{
+		Name:       name,
+		Body:       body,
+		SyntaxBody: f.Body.(*hclsyntax.Body),
+	}, diags
+}
+
+// diagnosticFromHCL converts the given HCL diagnostic into a tfconfig
+// diagnostic.
+func diagnosticFromHCL(hclDiag *hcl.Diagnostic) tfconfig.Diagnostic {
+	diag := tfconfig.Diagnostic{
+		Severity: tfconfig.DiagError,
+		Summary:  hclDiag.Summary,
+		Detail:   hclDiag.Detail,
+	}
+	if hclDiag.Severity == hcl.DiagWarning {
+		diag.Severity = tfconfig.DiagWarning
+	}
+	if hclDiag.Subject != nil {
+		diag.Pos = &tfconfig.SourcePos{
+			Filename: hclDiag.Subject.Filename,
+			Line:     hclDiag.Subject.Start.Line,
+		}
+	}
+	return diag
+}
+
+// checkReferences reports an error for each kept value that refers to
+// another object, such as var.example, because Terraform requires values in
+// tfvars files to be constant. References to the Filterer's Variables are
+// allowed.
+func (f *Filterer) checkReferences(ret *Result, sink DiagnosticSink) {
+	for _, name := range ret.Names {
+		syntaxAttr, ok := ret.Values[name]
+		if !ok {
+			continue
+		}
+		for _, traversal := range syntaxAttr.Expr.Variables() {
+			if _, ok := f.Variables[traversal.RootName()]; ok {
+				continue
+			}
+			rng := traversal.SourceRange()
+			sink.Append(tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Reference in tfvars value",
+				Detail:   fmt.Sprintf("The value for %q refers to %s, but values in tfvars files must be literal values that don't refer to variables, locals, or other objects.", name, traversalString(traversal)),
+				Pos: &tfconfig.SourcePos{
+					Filename: rng.Filename,
+					Line:     rng.Start.Line,
+				},
+			})
+		}
+	}
+}
+
+// traversalString returns a string representation of the given traversal's
+// leading attribute names, like "var.example", for use in messages.
+func traversalString(traversal hcl.Traversal) string {
+	var buf strings.Builder
+	for _, step := range traversal {
+		switch step := step.(type) {
+		case hcl.TraverseRoot:
+			buf.WriteString(step.Name)
+		case hcl.TraverseAttr:
+			buf.WriteString(".")
+			buf.WriteString(step.Name)
+		default:
+			return buf.String()
+		}
+	}
+	return buf.String()
+}
+
+// evaluate replaces each kept value in the given result that refers to the
+// Filterer's Variables with the result of evaluating it, modifying the
+// result in-place.
+func (f *Filterer) evaluate(ret *Result, sink DiagnosticSink) {
+	ctx := &hcl.EvalContext{
+		Variables: f.Variables,
+	}
+
+	for _, name := range ret.Names {
+		syntaxAttr, ok := ret.Values[name]
+		if !ok || len(syntaxAttr.Expr.Variables()) == 0 {
+			continue
+		}
+		allowed := true
+		for _, traversal := range syntaxAttr.Expr.Variables() {
+			if _, ok := f.Variables[traversal.RootName()]; !ok {
+				allowed = false // already reported by checkReferences
+			}
+		}
+		if !allowed {
+			continue
+		}
+
+		val, hclDiags := syntaxAttr.Expr.Value(ctx)
+		for _, hclDiag := range hclDiags {
+			sink.Append(diagnosticFromHCL(hclDiag))
+		}
+		if hclDiags.HasErrors() {
+			continue
+		}
+
+		attr, newSyntaxAttr, err := attributeForValue(syntaxAttr, val)
+		if err != nil {
+			sink.Append(tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Invalid evaluated value",
+				Detail:   fmt.Sprintf("The value for %q can't be written as a tfvars value after evaluation: %s.", name, err),
+				Pos: &tfconfig.SourcePos{
+					Filename: syntaxAttr.SrcRange.Filename,
+					Line:     syntaxAttr.SrcRange.Start.Line,
+				},
+			})
+			continue
+		}
+		ret.Attrs[name] = attr
+		ret.Values[name] = newSyntaxAttr
+	}
+}
+
+// transform applies the Filterer's Transform function to each of the values
+// in the given result, modifying it in-place.
+func (f *Filterer) transform(ret *Result, sink DiagnosticSink) {
+	for _, name := range ret.Names {
+		syntaxAttr, ok := ret.Values[name]
+		if !ok {
+			continue
+		}
+
+		val, hclDiags := syntaxAttr.Expr.Value(nil)
+		if hclDiags.HasErrors() {
+			// Transform functions only work with valid values, so we'll
+			// leave this one unchanged for Terraform to report.
+			continue
+		}
+
+		newVal, diag := f.Transform(name, val)
+		if diag != nil {
+			if diag.Pos == nil {
+				diag.Pos = &tfconfig.SourcePos{
+					Filename: syntaxAttr.SrcRange.Filename,
+					Line:     syntaxAttr.SrcRange.Start.Line,
+				}
+			}
+			sink.Append(*diag)
+		}
+		if newVal == cty.NilVal || (diag != nil && diag.Severity == tfconfig.DiagError) {
+			delete(ret.Attrs, name)
+			delete(ret.Values, name)
+			ret.Dropped = append(ret.Dropped, NewDroppedValue(syntaxAttr, "it was rejected by a transform"))
+			continue
+		}
+		if newVal.RawEquals(val) {
+			continue
+		}
+
+		// The new value replaces the whole attribute, so any comments
+		// that were attached to the original definition are lost.
+		attr, newSyntaxAttr, err := attributeForValue(syntaxAttr, newVal)
+		if err != nil {
+			sink.Append(tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Invalid transformed value",
+				Detail:   fmt.Sprintf("The transformed value for %q can't be written as a tfvars value: %s.", name, err),
+				Pos: &tfconfig.SourcePos{
+					Filename: syntaxAttr.SrcRange.Filename,
+					Line:     syntaxAttr.SrcRange.Start.Line,
+				},
+			})
+			continue
+		}
+		ret.Attrs[name] = attr
+		ret.Values[name] = newSyntaxAttr
+	}
+}
+
+// attributeForValue returns new definitions of the given attribute with the
+// given value, retaining the source position of the original.
+func attributeForValue(orig *hclsyntax.Attribute, val cty.Value) (*hclwrite.Attribute, *hclsyntax.Attribute, error) {
+	if !val.IsWhollyKnown() {
+		return nil, nil, fmt.Errorf("the value is not known")
+	}
+
+	// SetAttributeValue doesn't return the new attribute when it's newly
+	// created, so we look it up again afterwards.
+	f := hclwrite.NewEmptyFile()
+	f.Body().SetAttributeValue(orig.Name, val)
+	attr := f.Body().GetAttribute(orig.Name)
+
+	exprSrc := hclwrite.TokensForValue(val).Bytes()
+	expr, hclDiags := hclsyntax.ParseExpression(exprSrc, orig.SrcRange.Filename, orig.Expr.Range().Start)
+	if hclDiags.HasErrors() {
+		return nil, nil, hclDiags
+	}
+
+	return attr, &hclsyntax.Attribute{
+		Name:        orig.Name,
+		Expr:        expr,
+		SrcRange:    orig.SrcRange,
+		NameRange:   orig.NameRange,
+		EqualsRange: orig.EqualsRange,
+	}, nil
+}
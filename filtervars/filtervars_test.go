@@ -0,0 +1,163 @@
+package filtervars_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+	"github.com/apparentlymart/terraform-filter-vars/internal/filtertest"
+)
+
+func TestFilterNoUndeclaredOutput(t *testing.T) {
+	mod := filtertest.Module("foo", "bar")
+	sources := []*filtervars.Source{
+		filtertest.Source(t, "a.tfvars", `
+foo = "a"
+Foo = "differs only in case"
+FOO = "also differs only in case"
+foo {
+  nested = "a block with a declared name"
+}
+bar = { foo = "an undeclared name nested in a declared value" }
+`),
+		filtertest.Source(t, "b.tfvars", `
+baz = "undeclared, and later than the declared values"
+`),
+		filtertest.Source(t, "c.tfvars", `
+Bar = "only undeclared names in this file"
+baz = "undeclared again"
+`),
+	}
+
+	f := &filtervars.Filterer{
+		Module:          mod,
+		AllowUndeclared: map[string]bool{"baz": true},
+	}
+	result, diags := f.Filter(sources)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %#v", diags)
+	}
+
+	for _, name := range []string{"Foo", "FOO", "Bar", "baz"} {
+		if _, ok := result.Undeclared[name]; !ok {
+			t.Errorf("%q isn't recorded as undeclared", name)
+		}
+	}
+	for name := range result.Attrs {
+		if _, declared := mod.Variables[name]; !declared {
+			t.Errorf("result includes undeclared %q", name)
+		}
+	}
+	for _, attrs := range result.SourceAttrs {
+		for name := range attrs {
+			if _, declared := mod.Variables[name]; !declared {
+				t.Errorf("per-source result includes undeclared %q", name)
+			}
+		}
+	}
+
+	// The serialized result must also contain only declared variables,
+	// and no blocks at all.
+	out := filtertest.Output(result)
+	outF, hclDiags := hclsyntax.ParseConfig(out, "output", hcl.Pos{Line: 1, Column: 1})
+	if hclDiags.HasErrors() {
+		t.Fatalf("invalid output: %s\n%s", hclDiags.Error(), out)
+	}
+	body := outF.Body.(*hclsyntax.Body)
+	if len(body.Blocks) != 0 {
+		t.Errorf("output includes blocks:\n%s", out)
+	}
+	for name := range body.Attributes {
+		if _, declared := mod.Variables[name]; !declared {
+			t.Errorf("output includes undeclared %q:\n%s", name, out)
+		}
+	}
+	if got, want := len(body.Attributes), 2; got != want {
+		t.Errorf("wrong number of values in output: got %d, want %d\n%s", got, want, out)
+	}
+}
+
+// benchVarFile returns tfvars content defining the variables with the given
+// names, with a mixture of primitive and collection values.
+func benchVarFile(names []string, file int) string {
+	var buf bytes.Buffer
+	for i, name := range names {
+		switch i % 3 {
+		case 0:
+			fmt.Fprintf(&buf, "%s = \"value %d from file %d\"\n", name, i, file)
+		case 1:
+			fmt.Fprintf(&buf, "%s = [\"a\", \"b\", %d]\n", name, file)
+		default:
+			fmt.Fprintf(&buf, "# The %s setting.\n%s = {\n  key   = \"v\"\n  count = %d\n}\n", name, name, i)
+		}
+	}
+	return buf.String()
+}
+
+// benchNames returns the given number of variable names.
+func benchNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("var_%04d", i)
+	}
+	return names
+}
+
+func BenchmarkFilterSingleFile(b *testing.B) {
+	names := benchNames(200)
+	mod := filtertest.Module(names[:150]...)
+	sources := []*filtervars.Source{filtertest.Source(b, "bench.tfvars", benchVarFile(names, 0))}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, diags := (&filtervars.Filterer{Module: mod}).Filter(sources); len(diags) != 0 {
+			b.Fatalf("unexpected diagnostics: %#v", diags)
+		}
+	}
+}
+
+func BenchmarkFilterMultiFileMerge(b *testing.B) {
+	names := benchNames(500)
+	mod := filtertest.Module(names[:400]...)
+	sources := make([]*filtervars.Source, 50)
+	for i := range sources {
+		// Each file overrides a different window of the variables, so
+		// that the merge has plenty of overriding to do.
+		start := (i * 10) % len(names)
+		window := append(append([]string(nil), names[start:]...), names[:start]...)[:200]
+		sources[i] = filtertest.Source(b, fmt.Sprintf("bench%02d.tfvars", i), benchVarFile(window, i))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		(&filtervars.Filterer{Module: mod}).Filter(sources)
+	}
+}
+
+func BenchmarkLoadModule(b *testing.B) {
+	dir := filtertest.TempDir(b)
+	defer os.RemoveAll(dir)
+
+	names := benchNames(300)
+	for file := 0; file < 10; file++ {
+		var buf bytes.Buffer
+		for _, name := range names[file*30 : (file+1)*30] {
+			fmt.Fprintf(&buf, "variable %q {\n  type        = string\n  default     = \"x\"\n  description = \"The %s setting.\"\n}\n\n", name, name)
+		}
+		filtertest.WriteFile(b, dir, fmt.Sprintf("variables%02d.tf", file), buf.String())
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mod, diags := tfconfig.LoadModule(dir)
+		if diags.HasErrors() || len(mod.Variables) != len(names) {
+			b.Fatalf("failed to load module: %s", diags.Err())
+		}
+	}
+}
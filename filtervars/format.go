@@ -0,0 +1,86 @@
+package filtervars
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+)
+
+// Format is the name of an output format for filtered variables, as given
+// to the --format option of terraform-filter-vars.
+type Format string
+
+// The output formats built in to terraform-filter-vars. Their serializers
+// are in the command rather than in this package, so these can't be looked
+// up with LookupFormat.
+const (
+	FormatTFVars   Format = "tfvars"
+	FormatHCLMap   Format = "hcl-map"
+	FormatMarkdown Format = "markdown"
+	FormatTFC      Format = "tfc"
+	FormatVarArgs  Format = "var-args"
+	FormatLocals   Format = "locals"
+)
+
+// SerializeFunc is the signature of a function that writes the given
+// variables to w in some output format. Names gives the order in which the
+// variables should be written, and any name that doesn't have an attribute
+// in attrs should be skipped.
+//
+// If the variables can't be represented in the format then the function
+// returns error diagnostics explaining why, and whatever it wrote to w is
+// discarded.
+type SerializeFunc func(w io.Writer, mod *tfconfig.Module, names []string, attrs map[string]*hclwrite.Attribute) []tfconfig.Diagnostic
+
+var (
+	formatsMu sync.RWMutex
+	formats   = make(map[Format]SerializeFunc)
+)
+
+// RegisterFormat makes a custom output format available by the given name,
+// so that terraform-filter-vars accepts the name for its --format option.
+//
+// This is intended to be called from an init function. It panics if a
+// format of the same name is already registered or is built in.
+func RegisterFormat(name Format, fn SerializeFunc) {
+	if fn == nil {
+		panic(fmt.Sprintf("filtervars: serializer for format %q is nil", name))
+	}
+	switch name {
+	case FormatTFVars, FormatHCLMap, FormatMarkdown, FormatTFC, FormatVarArgs, FormatLocals:
+		panic(fmt.Sprintf("filtervars: format %q is built in", name))
+	}
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if _, exists := formats[name]; exists {
+		panic(fmt.Sprintf("filtervars: format %q is already registered", name))
+	}
+	formats[name] = fn
+}
+
+// LookupFormat returns the serializer for the custom output format with the
+// given name, or false if no such format is registered.
+func LookupFormat(name Format) (SerializeFunc, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	fn, ok := formats[name]
+	return fn, ok
+}
+
+// Formats returns the names of all of the registered custom output formats,
+// in lexical order. The result doesn't include the built-in formats.
+func Formats() []Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	ret := make([]Format, 0, len(formats))
+	for name := range formats {
+		ret = append(ret, name)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// fixVarFiles rewrites each of the given tfvars files in place so that it
+// contains only the definitions of declared variables, and those of the
+// given allowed undeclared variables, in canonical formatting, for --fix.
+// The given sources must have been read from the given paths, in the same
+// order.
+//
+// If check is set then the files are left unchanged, and instead there's an
+// error for each file that would be rewritten. If dryRun is set then the
+// files are also left unchanged, and each file that would be rewritten is
+// just reported.
+//
+// Only native syntax files in the local filesystem can be fixed, so others
+// are skipped with a warning.
+func fixVarFiles(diags []tfconfig.Diagnostic, mod *tfconfig.Module, paths []string, sources []*filtervars.Source, allowUndeclared map[string]bool, check, dryRun bool) []tfconfig.Diagnostic {
+	for i, arg := range paths {
+		src := sources[i]
+		if src == nil {
+			continue
+		}
+		path, _ := splitVarFileFormat(arg)
+		if src.FromJSON || remoteVarFile(path) {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagWarning,
+				Summary:  "Can't fix file",
+				Detail:   fmt.Sprintf("Only local files in native syntax can be fixed, so %s is unchanged.", path),
+			})
+			continue
+		}
+
+		orig, err := ioutil.ReadFile(path)
+		if err != nil {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Failed to read input file",
+				Detail:   fmt.Sprintf("Can't read %s: %s.", path, err),
+			})
+			continue
+		}
+		fixed, hclDiags := fixedVarFile(mod, orig, path, allowUndeclared)
+		diags = appendHCLDiags(diags, hclDiags)
+		if hclDiags.HasErrors() || bytes.Equal(orig, fixed) {
+			continue
+		}
+
+		if check {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "File needs fixing",
+				Detail:   fmt.Sprintf("The file %s includes undeclared variables or isn't in canonical formatting. Run again with --fix and without --check to rewrite it.", path),
+			})
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(os.Stderr, "Would rewrite %s\n", path)
+			continue
+		}
+		if err := writeFileAtomic(path, fixed); err != nil {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Failed to fix file",
+				Detail:   fmt.Sprintf("Can't write %s: %s.", path, err),
+			})
+			continue
+		}
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: diagInfo,
+			Summary:  "Fixed file",
+			Detail:   fmt.Sprintf("Rewrote %s.", path),
+		})
+	}
+	return diags
+}
+
+// fixedVarFile returns the content that fixVarFiles would write in place of
+// the given original content of the file at the given path. The definitions
+// of undeclared variables are removed along with any comments attached to
+// them, but everything else is kept, including comments that aren't attached
+// to any definition.
+//
+// This works from the original content rather than from the source that was
+// read, so that nothing added by preprocessing is written back to the file.
+func fixedVarFile(mod *tfconfig.Module, orig []byte, path string, allowUndeclared map[string]bool) ([]byte, hcl.Diagnostics) {
+	f, hclDiags := hclwrite.ParseConfig(orig, path, hcl.Pos{Line: 1, Column: 1})
+	if hclDiags.HasErrors() {
+		return nil, hclDiags
+	}
+	body := f.Body()
+	for name := range body.Attributes() {
+		if _, declared := mod.Variables[name]; !declared && !allowUndeclared[name] {
+			body.RemoveAttribute(name)
+		}
+	}
+	return hclwrite.Format(f.Bytes()), hclDiags
+}
+
+// writeFileAtomic replaces the content of the file at the given path with
+// the given content by writing a temporary file in the same directory and
+// then renaming it into place, so that a reader never sees a partial file.
+// The file keeps its original permissions, and a symlink is followed so that
+// its target is replaced rather than the link itself.
+//
+// If the file doesn't exist yet then it's first created empty, so that its
+// permissions are decided by the umask as for os.Create. It's removed again
+// if the write fails.
+func writeFileAtomic(path string, content []byte) (err error) {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		info, err = createEmptyFile(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				os.Remove(path)
+			}
+		}()
+	}
+	if err != nil {
+		return err
+	}
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".")
+	if err != nil {
+		return err
+	}
+	// Removing the temporary file fails harmlessly once it's been renamed.
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// createEmptyFile creates a new empty file at the given path, failing if it
+// already exists, and returns its details.
+func createEmptyFile(path string) (os.FileInfo, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return os.Stat(path)
+}
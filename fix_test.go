@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestCLIFix(t *testing.T) {
+	const messy = `# Settings for the web tier.
+region = "us-east-1"
+name = "web" # the tier name
+
+# No longer used.
+extra = 1
+`
+	const fixed = `# Settings for the web tier.
+region = "us-east-1"
+name   = "web" # the tier name
+
+`
+
+	runCLITests(t, map[string]cliTest{
+		"rewrites in place": {
+			Files:     withTestModule(map[string]string{"a.tfvars": messy}),
+			Args:      []string{"--fix", "mod", "a.tfvars"},
+			WantFiles: map[string]string{"a.tfvars": fixed},
+		},
+		"already fixed": {
+			Files:     withTestModule(map[string]string{"a.tfvars": fixed}),
+			Args:      []string{"--fix", "--check", "mod", "a.tfvars"},
+			WantFiles: map[string]string{"a.tfvars": fixed},
+		},
+		"check": {
+			Files:      withTestModule(map[string]string{"a.tfvars": messy}),
+			Args:       []string{"--fix", "--check", "mod", "a.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"Error: File needs fixing; The file a.tfvars includes undeclared variables"},
+			WantFiles:  map[string]string{"a.tfvars": messy},
+		},
+		"dry run": {
+			Files:      withTestModule(map[string]string{"a.tfvars": messy}),
+			Args:       []string{"--fix", "--dry-run", "mod", "a.tfvars"},
+			WantStderr: []string{"Would rewrite a.tfvars\n"},
+			WantFiles:  map[string]string{"a.tfvars": messy},
+		},
+		"allowed undeclared variable": {
+			Files: withTestModule(map[string]string{"a.tfvars": "region = \"us-east-1\"\nextra = 1\n"}),
+			Args:  []string{"--fix", "--allow-undeclared", "extra", "mod", "a.tfvars"},
+			WantFiles: map[string]string{
+				"a.tfvars": "region = \"us-east-1\"\nextra  = 1\n",
+			},
+		},
+		"JSON file": {
+			Files:      withTestModule(map[string]string{"a.tfvars.json": `{"region": "us-east-1", "extra": 1}`}),
+			Args:       []string{"--fix", "mod", "a.tfvars.json"},
+			WantStderr: []string{"Warning: Can't fix file; Only local files in native syntax can be fixed, so a.tfvars.json is unchanged."},
+			WantFiles:  map[string]string{"a.tfvars.json": `{"region": "us-east-1", "extra": 1}`},
+		},
+		"check without fix": {
+			Files:      withTestModule(nil),
+			Args:       []string{"--check", "mod"},
+			WantExit:   1,
+			WantStderr: []string{"Error: Invalid option; The --check option can be used only with --fix."},
+		},
+		"with preprocessing": {
+			Files:      withTestModule(map[string]string{"a.tfvars": messy}),
+			Args:       []string{"--fix", "--normalize-literals", "mod", "a.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"Error: Conflicting input options; The --fix option rewrites the files as they were written"},
+			WantFiles:  map[string]string{"a.tfvars": messy},
+		},
+	})
+}
@@ -4,7 +4,8 @@ go 1.12
 
 require (
 	github.com/hashicorp/hcl/v2 v2.0.0
-	github.com/hashicorp/terraform-config-inspect v0.0.0-20190821133035-82a99dc22ef4
+	github.com/hashicorp/terraform-config-inspect v0.0.0-20211115214459-90acf1ca460f
 	github.com/spf13/pflag v1.0.5
+	github.com/zclconf/go-cty v1.1.0
 	golang.org/x/crypto v0.0.0-20191105034135-c7e5f84aec59 // indirect
 )
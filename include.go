@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// includeDirective matches a line like #include other.tfvars, for
+// --follow-includes. The path may optionally be in double quotes.
+var includeDirective = regexp.MustCompile(`^#include[ \t]+(.+?)[ \t]*$`)
+
+// readIncludes finds each #include line in the given tfvars source, read
+// from the given path, and loads the named file as a source of its own, with
+// its own includes followed in turn. Relative paths are taken relative to
+// the directory containing the including file.
+//
+// The result is the given source with each #include line blanked out, so
+// that the remaining lines keep their positions, along with the included
+// sources in the order they were included.
+func (r *varFileReader) readIncludes(src []byte, path string) ([]byte, []*filtervars.Source, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	// The stack is the absolute paths of the files whose includes are
+	// already being read, so that we can report an include cycle instead
+	// of recursing forever.
+	stack := r.includeStack
+	if abs, err := filepath.Abs(path); err == nil {
+		stack = append(stack[:len(stack):len(stack)], abs)
+	}
+
+	var included []*filtervars.Source
+	lines := bytes.SplitAfter(src, []byte{'\n'})
+	var buf bytes.Buffer
+	for i, line := range lines {
+		m := includeDirective.FindSubmatch(bytes.TrimRight(line, "\r\n"))
+		if m == nil {
+			buf.Write(line)
+			continue
+		}
+		buf.WriteByte('\n')
+		pos := &tfconfig.SourcePos{
+			Filename: path,
+			Line:     i + 1,
+		}
+
+		includePath := strings.Trim(string(m[1]), `"`)
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+		if abs, err := filepath.Abs(includePath); err == nil {
+			for _, other := range stack {
+				if other == abs {
+					diags = append(diags, tfconfig.Diagnostic{
+						Severity: tfconfig.DiagError,
+						Summary:  "Include cycle",
+						Detail:   fmt.Sprintf("The file %s is already being included, by way of %s.", includePath, strings.Join(stack, " -> ")),
+						Pos:      pos,
+					})
+					return nil, nil, diags
+				}
+			}
+		}
+
+		incSrc, err := ioutil.ReadFile(includePath)
+		if err != nil {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Failed to read included file",
+				Detail:   fmt.Sprintf("Can't read %s: %s.", includePath, err),
+				Pos:      pos,
+			})
+			return nil, nil, diags
+		}
+
+		// The included file is loaded by a copy of the reader that knows
+		// which files are already being included.
+		sub := *r
+		sub.includeStack = stack
+		vf, moreDiags := sub.Load(incSrc, includePath, "")
+		diags = append(diags, moreDiags...)
+		if vf == nil {
+			return nil, nil, diags
+		}
+		included = append(included, vf)
+	}
+	return buf.Bytes(), included, diags
+}
+
+// mergeIncludes returns a source that has all of the definitions from the
+// given source along with those from the given included sources that the
+// source doesn't define itself. Where several included sources define the
+// same variable, the last one takes precedence, as if the included sources
+// were given before the source itself on the command line.
+//
+// The included definitions keep their own positions, so diagnostics about
+// them refer to the included files.
+func mergeIncludes(src *filtervars.Source, included []*filtervars.Source) (*filtervars.Source, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	var names []string
+	defs := make(map[string]*filtervars.Source)
+	for _, inc := range included {
+		for _, name := range inc.AttributeNames() {
+			if _, own := src.SyntaxBody.Attributes[name]; own {
+				continue
+			}
+			if _, exists := defs[name]; !exists {
+				names = append(names, name)
+			}
+			defs[name] = inc
+		}
+	}
+	if len(names) == 0 {
+		return src, diags
+	}
+
+	// The included definitions go before the source's own content, which
+	// we then parse again so that the output body has an attribute for
+	// each of them.
+	var buf bytes.Buffer
+	syntaxBody := *src.SyntaxBody
+	syntaxBody.Attributes = make(map[string]*hclsyntax.Attribute, len(src.SyntaxBody.Attributes)+len(names))
+	for name, attr := range src.SyntaxBody.Attributes {
+		syntaxBody.Attributes[name] = attr
+	}
+	for _, name := range names {
+		inc := defs[name]
+		toks := inc.Body.GetAttribute(name).BuildTokens(nil).Bytes()
+		buf.Write(toks)
+		if len(toks) > 0 && toks[len(toks)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+		syntaxBody.Attributes[name] = inc.SyntaxBody.Attributes[name]
+	}
+	buf.Write(src.Body.BuildTokens(nil).Bytes())
+
+	f, hclDiags := hclwrite.ParseConfig(buf.Bytes(), src.Name, hcl.Pos{Line: 1, Column: 1})
+	diags = appendHCLDiags(diags, hclDiags)
+	if hclDiags.HasErrors() {
+		return nil, diags
+	}
+	return &filtervars.Source{
+		Name:       src.Name,
+		Body:       f.Body(),
+		SyntaxBody: &syntaxBody,
+	}, diags
+}
@@ -0,0 +1,91 @@
+// Package filtertest contains helpers shared by the tests of
+// terraform-filter-vars and of its filtervars package.
+package filtertest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// TempDir creates a temporary directory for the given test, returning its
+// path. The caller should remove it once the test is complete.
+func TempDir(t testing.TB) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "terraform-filter-vars-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// WriteFile writes the given content to the file at the given path relative
+// to the given directory, creating any intermediate directories, and returns
+// the full path.
+func WriteFile(t testing.TB, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Module returns a module that declares required variables with the given
+// names and no type constraints.
+func Module(names ...string) *tfconfig.Module {
+	mod := &tfconfig.Module{
+		Variables: make(map[string]*tfconfig.Variable, len(names)),
+	}
+	for _, name := range names {
+		mod.Variables[name] = &tfconfig.Variable{Name: name, Required: true}
+	}
+	return mod
+}
+
+// Source parses the given native syntax tfvars content as a source with the
+// given name, failing the given test if it's invalid.
+func Source(t testing.TB, name, src string) *filtervars.Source {
+	t.Helper()
+	f, hclDiags := hclwrite.ParseConfig([]byte(src), name, hcl.Pos{Line: 1, Column: 1})
+	if hclDiags.HasErrors() {
+		t.Fatal(hclDiags.Error())
+	}
+	ret, diags := filtervars.SourceFromBody(name, f.Body())
+	if ret == nil {
+		t.Fatalf("invalid source %s: %#v", name, diags)
+	}
+	return ret
+}
+
+// NoErrors fails the given test if any of the given diagnostics are errors.
+func NoErrors(t testing.TB, diags []tfconfig.Diagnostic) {
+	t.Helper()
+	for _, diag := range diags {
+		if diag.Severity == tfconfig.DiagError {
+			t.Fatalf("unexpected error: %s; %s", diag.Summary, diag.Detail)
+		}
+	}
+}
+
+// Output concatenates the winning definitions in the given result, in order,
+// which is the simplest serialization of it.
+func Output(result *filtervars.Result) []byte {
+	var buf bytes.Buffer
+	result.Each(func(name string, attr *hclwrite.Attribute) error {
+		buf.Write(attr.BuildTokens(nil).Bytes())
+		return nil
+	})
+	return buf.Bytes()
+}
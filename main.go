@@ -3,152 +3,979 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/terraform-config-inspect/tfconfig"
 	flag "github.com/spf13/pflag"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
 )
 
-var GitCommit string
-var Version = "v0.0.0"
-var Prerelease = "dev"
+// diagInfo is an additional diagnostic severity, beyond those tfconfig
+// defines, for informational notes that are shown only in verbose mode.
+const diagInfo tfconfig.DiagSeverity = 'I'
+
+// verbose is set by the --verbose option, and causes showDiags to include
+// informational notes.
+var verbose bool
+
+// diagnosticsFormat is set by the --diagnostics-format option, and selects
+// how showDiags writes each diagnostic.
+var diagnosticsFormat string
 
 func main() {
 	flag.Usage = usage
 
 	versionP := flag.BoolP("version", "v", false, "show version information")
 	outP := flag.StringP("out", "o", "-", "output to a given file, instead of stdout")
+	requireFilesP := flag.Bool("require-files", false, "fail if no tfvars files are given")
+	strictP := flag.Bool("strict", false, "treat suspicious tfvars file content as an error")
+	splitByFileP := flag.Bool("split-by-file", false, "filter each tfvars file separately, writing the results into --out-dir")
+	outDirP := flag.String("out-dir", "", "directory to write results into when using --split-by-file")
+	timingsP := flag.Bool("timings", false, "report how long each phase of processing took")
+	dryRunP := flag.Bool("dry-run", false, "report what would be written, without writing anything")
+	emitDeclsP := flag.Bool("emit-declarations", false, "output variable declarations for the undeclared variables, instead of values")
+	dataP := flag.String("data", "", "JSON file of values to substitute for ${data.key} references in the tfvars files")
+	templateVarsP := flag.String("template-vars", "", "declare the variables that are defined in the given tfvars file, instead of or in addition to a module; these have no type constraint, and are kept regardless of --only")
+	checkTypesP := flag.Bool("check-types", false, "check that the given values conform to the variables' type constraints")
+	flag.BoolVar(&verbose, "verbose", false, "show informational notes as well as warnings and errors")
+	flag.StringVar(&diagnosticsFormat, "diagnostics-format", "text", "format for errors and warnings: \"text\", or \"github\" for GitHub Actions workflow commands that annotate the files")
+	compressP := flag.Bool("compress", false, "gzip-compress the output, which is implied if the output filename ends with .gz")
+	formatP := flag.String("format", "tfvars", "output format: "+strings.Join(quotedFormats(outputFormats()), ", ")+", or \"json\" with --version or --count-only")
+	variablesOnlyP := flag.Bool("variables-only", false, "treat module errors unrelated to variable declarations as warnings")
+	filesFromP := flag.String("files-from", "", "read a list of tfvars files from the given file, to process before any given as arguments")
+	maxErrorsP := flag.Int("max-errors", 0, "stop after reporting the given number of errors, or 0 for no limit")
+	fmtP := flag.Bool("fmt", false, "rewrite the output in the canonical HCL style")
+	indentP := flag.Int("indent", 2, "number of spaces per level of indentation in formatted output, implying --fmt")
+	useTabsP := flag.Bool("use-tabs", false, "indent formatted output with tabs instead of spaces, implying --fmt")
+	envP := flag.Bool("env", false, "also take values from TF_VAR_ environment variables, with lower precedence than any tfvars file")
+	failOnDropP := flag.Bool("fail-on-drop", false, "fail if any given value would not be included in the output")
+	summaryP := flag.Bool("summary", false, "show a one-line summary of the result")
+	noSummaryP := flag.Bool("no-summary", false, "don't show a summary, even if --summary is set in a config file")
+	annotateSourceP := flag.Bool("annotate-source", false, "add a comment after each value in the output saying which file and line it came from")
+	readRetriesP := flag.Int("read-retries", 0, "number of times to retry reading a tfvars file after a transient error")
+	documentedOnlyP := flag.Bool("documented-only", false, "only include variables that have a description in the module")
+	positionsJSONP := flag.String("positions-json", "", "also write a JSON file describing where each kept variable is defined in the input files")
+	normalizeLiteralsP := flag.Bool("normalize-literals", false, "rewrite values like True or NULL as the keywords true or null")
+	routesP := flag.StringArray("route", nil, "write variables whose names match a pattern to a separate file, given as pattern=path; may be repeated")
+	wrapStringsP := flag.Int("wrap-strings", 0, "write string values longer than the given length as heredocs wrapped to that length, which inserts newlines into the values")
+	eolP := flag.String("eol", "", "line ending to use in the output, either lf or crlf; by default line endings are kept as in the input")
+	sshIdentityP := flag.String("ssh-identity", "", "private key file to use when reading tfvars files from scp:// paths")
+	explainP := flag.String("explain", "", "describe the declaration and definitions of the named variable instead of writing any output")
+	expandEnvP := flag.Bool("expand-env", false, "replace ${env.NAME} references in values with the value of the environment variable NAME")
+	expandEnvDefaultP := flag.String("expand-env-default", "", "value to use with --expand-env for environment variables that aren't set, instead of reporting an error")
+	checkDefaultsMatchP := flag.Bool("check-defaults-match", false, "report an error for each value that is the same as its variable's default value")
+	sensitivityFromStateP := flag.String("sensitivity-from-state", "", "also treat as sensitive any variable with the same name as a sensitive output in the given Terraform state file")
+	requireWriteP := flag.Bool("require-write", false, "fail if there are no variables to write, rather than writing an empty result")
+	validateCmdP := flag.String("validate-cmd", "", "shell command that must accept the output on its stdin, exiting successfully, before it is written")
+	extraModulesP := flag.StringArray("extra-module", nil, "also keep values for variables declared in the given module directory; may be repeated")
+	modulePrecedenceP := flag.StringSlice("module-precedence", nil, "comma-separated module directories, highest precedence first, deciding which declaration is used when several modules declare the same variable")
+	noTrimP := flag.Bool("no-trim", false, "keep whitespace at the ends of lines in tfvars, map, and locals output, instead of removing it where it isn't part of a value")
+	onlyP := flag.String("only", "", "only include variables that are \"required\", having no default value, or \"optional\"")
+	warnEmptyRequiredP := flag.Bool("warn-empty-required", false, "warn about required variables whose values are null, empty strings, or empty collections")
+	stdinMultiP := flag.Bool("stdin-multi", false, "also read tfvars files from stdin, separated by lines like \"# --- file: name ---\"")
+	stableFromP := flag.String("stable-from", "", "keep the exact definitions from the given existing output file for any values that haven't changed")
+	countOnlyP := flag.Bool("count-only", false, "write only a line of counts describing the result, or a JSON object with --format=json, instead of the result itself")
+	allowUndeclaredP := flag.StringArray("allow-undeclared", nil, "name of an undeclared variable that is expected in the tfvars files, and so isn't reported as dropped; may be repeated")
+	passAllowedP := flag.Bool("pass-allowed", false, "include the values of the --allow-undeclared variables in the output, as if they were declared")
+	coerceP := flag.Bool("coerce", false, "convert each value to its variable's declared type, writing the converted value")
+	recursiveP := flag.Bool("recursive", false, "read tfvars files from all subdirectories of any directory given as a tfvars file")
+	latestP := flag.Bool("latest", false, "use only the most recently modified of the given tfvars files, instead of merging them all")
+	dropNullsP := flag.Bool("drop-nulls", false, "ignore definitions whose values are null, as if they were not set at all, so that any earlier definition of the same variable is used instead")
+	denyValuesP := flag.String("deny-values", "", "file listing forbidden literal values, one per line, that no kept value may include")
+	inlineVarsP := flag.StringArray("inline-vars", nil, "tfvars content to use as if it were a file, taking precedence only over environment variables; may be repeated")
+	autoLoadP := flag.Bool("auto-load", false, "also read terraform.tfvars and *.auto.tfvars files from the module directory, as Terraform would, before any other files")
+	requireTfvarsP := flag.Bool("require-tfvars", false, "fail if the module directory has no terraform.tfvars file, and warn about any *.auto.tfvars files in it")
+	noAutoOverrideP := flag.Bool("no-auto-override", false, "warn about each value from an automatically-loaded file that is overridden by a later source, with --auto-load")
+	normalizeNumbersP := flag.Bool("normalize-numbers", false, "rewrite number values in a canonical form, like 1 instead of 1.0")
+	snapshotP := flag.String("snapshot", "", "also write a JSON file recording each kept value along with hashes of its source file and of the module's variable declarations")
+	skipNullInputP := flag.Bool("skip-null-input", false, "treat null values in JSON tfvars files as unset, so that earlier sources' values for the same variables are kept")
+	aliasesP := flag.StringArray("alias", nil, "use the value given for a name, like 'region=primary_region,replica_region', for each of the listed declared variables that has no value of its own; may be repeated")
+	declaredFromFilesP := flag.StringSlice("declared-from-files", nil, "declare the variables that are defined in any of the given comma-separated tfvars files, like --template-vars")
+	declaredPatternP := flag.String("declared-pattern", "", "regular expression matching the names of variables to keep as if they were declared, in addition to those the module declares")
+	warnSensitiveOverridesP := flag.Bool("warn-sensitive-overrides", false, "warn when a value for a sensitive variable overrides an earlier, different value, without showing either value")
+	groupBySourceP := flag.Bool("group-by-source", false, "group the variables in the output under a comment naming the file that provided each one's value, in the order the files were given")
+	failOnParseWarningP := flag.Bool("fail-on-parse-warning", false, "treat warnings from parsing the tfvars files as errors")
+	forResourceP := flag.String("for-resource", "", "only include variables that the given resource, data resource, or module call in the module refers to directly, like aws_instance.example")
+	suggestDistanceP := flag.Int("suggest-distance", 2, "maximum number of edits between an undeclared name and a declared one for --fail-on-drop to suggest it as a correction, or 0 for no suggestions")
+	bestEffortP := flag.Bool("best-effort", false, "skip tfvars files that can't be read, with a warning, failing only if none of them can be read")
+	inlineFilesP := flag.Bool("inline-files", false, "replace calls like file(\"cert.pem\") in values with the content of the named file, relative to the tfvars file")
+	fixP := flag.Bool("fix", false, "rewrite each of the given tfvars files in place so it contains only declared variables, in canonical formatting, instead of writing filtered output")
+	checkP := flag.Bool("check", false, "with --fix, report which files would be rewritten without changing them")
+	priorityP := flag.StringSlice("priority", nil, "comma-separated variable names to write first in the output, in the given order, before the rest in the usual order")
+	reportRedundantP := flag.Bool("report-redundant", false, "warn about each value that is the same as its variable's default value, while still including it in the output")
+	schemaOutP := flag.String("schema-out", "", "also write a JSON file describing each of the module's variable declarations, which doesn't require any tfvars files")
+	parallelismP := flag.Int("parallelism", runtime.NumCPU(), "maximum number of tfvars files to read at once, or 1 to read them one at a time")
+	provenanceP := flag.String("provenance", "", "also write a JSON file recording the absolute path and line of each kept value, and the other files that also defined it")
+	compareTerraformP := flag.Bool("compare-terraform", false, "describe how the values differ from those Terraform would use with the same tfvars files, instead of writing any output")
+	followIncludesP := flag.Bool("follow-includes", false, "follow lines like #include other.tfvars in tfvars files by reading the named file, relative to the including file, as if it were given just before the including file")
+	minVarsP := flag.Int("min-vars", 0, "fail if fewer than the given number of variables would be written")
+	maxVarsP := flag.Int("max-vars", 0, "fail if more than the given number of variables would be written, or 0 for no limit")
+	moduleJSONP := flag.String("module-json", "", "read variable declarations from terraform-config-inspect JSON output, instead of a module directory")
 	flag.Parse()
 
+	var diags []tfconfig.Diagnostic
+
 	if *versionP {
-		versionStr := Version
-		if Prerelease != "" {
-			versionStr = versionStr + "-" + Prerelease
+		if *formatP != "tfvars" && *formatP != "json" {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Unsupported output format",
+				Detail:   fmt.Sprintf("The version information can be shown only in \"tfvars\" or \"json\" format, not %q.", *formatP),
+			})
+			exitWithDiags(diags)
 		}
-		fmt.Printf("terraform-filter-vars %s\n", versionStr)
+		writeVersion(os.Stdout, currentBuildInfo(), *formatP)
 		os.Exit(0)
 	}
 
 	args := flag.Args()
-	if len(args) < 1 {
+	noModuleDir := *moduleJSONP != "" || *templateVarsP != "" || len(*declaredFromFilesP) > 0
+	if len(args) < 1 && !noModuleDir {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	var diags []tfconfig.Diagnostic
+	// When the variable declarations come from somewhere other than a
+	// module directory then all of the positional arguments are tfvars
+	// files.
+	var modDir string
+	varFilePaths := args
+	if !noModuleDir {
+		modDir = args[0]
+		varFilePaths = args[1:]
+	}
 
-	modDir := args[0]
-	mod, moreDiags := tfconfig.LoadModule(modDir)
-	diags = append(diags, moreDiags...)
+	if configFile := findConfigFile(modDir); configFile != "" {
+		diags = applyConfigFile(diags, configFile, flag.CommandLine)
+		exitIfErrors(diags)
+	}
+	if diagnosticsFormat != "text" && diagnosticsFormat != "github" {
+		format := diagnosticsFormat
+		diagnosticsFormat = "text"
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Unsupported diagnostics format",
+			Detail:   fmt.Sprintf("The diagnostics format %q is not supported; it must be \"text\" or \"github\".", format),
+		})
+		exitWithDiags(diags)
+	}
+	if !validOutputFormat(*formatP) && !(*countOnlyP && *formatP == "json") {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Unsupported output format",
+			Detail:   fmt.Sprintf("The format %q is not supported for filtering; it must be %s.", *formatP, quotedFormatList(outputFormats())),
+		})
+		exitWithDiags(diags)
+	}
+	if *onlyP != "" && *onlyP != "required" && *onlyP != "optional" {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Invalid variable selection",
+			Detail:   fmt.Sprintf("The --only option must be either \"required\" or \"optional\", not %q.", *onlyP),
+		})
+		exitWithDiags(diags)
+	}
+	if *eolP != "" && *eolP != "lf" && *eolP != "crlf" {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Unsupported line ending",
+			Detail:   fmt.Sprintf("The line ending %q is not supported; it must be either \"lf\" or \"crlf\".", *eolP),
+		})
+		exitWithDiags(diags)
+	}
+	if *filesFromP != "" {
+		manifestPaths, moreDiags := readManifest(*filesFromP)
+		diags = append(diags, moreDiags...)
+		exitIfErrors(diags)
+		varFilePaths = append(manifestPaths, varFilePaths...)
+	}
+	varFilePaths, diags = expandVarFilePaths(diags, varFilePaths, *recursiveP)
+	if *latestP && len(varFilePaths) > 0 {
+		varFilePaths, diags = selectLatestVarFile(diags, varFilePaths)
+	}
+	var autoLoadPaths []string
+	if *autoLoadP || *requireTfvarsP {
+		autoLoadPaths, diags = appendAutoLoadDiags(diags, modDir, *requireTfvarsP)
+		if !*autoLoadP {
+			autoLoadPaths = nil
+		}
+	}
 	exitIfErrors(diags)
-
-	wantedVars := make([]string, 0, len(mod.Variables))
-	wantedVarsSet := make(map[string]struct{}, len(mod.Variables))
-	for name := range mod.Variables {
-		wantedVars = append(wantedVars, name)
-		wantedVarsSet[name] = struct{}{}
+	if *requireFilesP && len(varFilePaths)+len(autoLoadPaths) == 0 {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "No tfvars files given",
+			Detail:   "At least one tfvars file is required when using --require-files, either given explicitly or found by --auto-load.",
+		})
+		exitWithDiags(diags)
 	}
-	sort.Strings(wantedVars)
 
-	attrs := make(map[string]*hclwrite.Attribute, len(wantedVars))
-	varFilePaths := args[1:]
-	for _, varFilePath := range varFilePaths {
-		if strings.HasSuffix(varFilePath, ".json") {
-			// For now we don't support JSON, because our output is a single
-			// native syntax vars definition. With some care we could
-			// potentially transform JSON expressions into native syntax ones,
-			// but that's tricky to get right and so we'll just stick to the
-			// common case of native syntax input files for now.
+	if *splitByFileP {
+		// The automatically-loaded files are written out too, so they
+		// mustn't collide with the explicit ones.
+		splitPaths := append(append([]string(nil), autoLoadPaths...), varFilePaths...)
+		diags = appendSplitByFileDiags(diags, *outDirP, *outP, splitPaths)
+		for _, opt := range []struct {
+			name string
+			set  bool
+		}{
+			{"--env", *envP},
+			{"--inline-vars", len(*inlineVarsP) > 0},
+			{"--stdin-multi", *stdinMultiP},
+		} {
+			if opt.set {
+				diags = append(diags, tfconfig.Diagnostic{
+					Severity: tfconfig.DiagError,
+					Summary:  "Conflicting input options",
+					Detail:   fmt.Sprintf("The %s option can't be used with --split-by-file, because there is no input file to name the output after.", opt.name),
+				})
+			}
+		}
+		exitIfErrors(diags)
+	}
+	if *compareTerraformP && modDir == "" {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "No module directory",
+			Detail:   "The --compare-terraform option needs a module directory to compare with, so it can't be used with --module-json, --template-vars, or --declared-from-files.",
+		})
+		exitWithDiags(diags)
+	}
+	if *checkP && !*fixP {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Invalid option",
+			Detail:   "The --check option can be used only with --fix.",
+		})
+	}
+	if *fixP && (*dataP != "" || *inlineFilesP || *normalizeLiteralsP || *followIncludesP) {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Conflicting input options",
+			Detail:   "The --fix option rewrites the files as they were written, so it can't be used with --data, --inline-files, --normalize-literals, or --follow-includes, which change the content before it's parsed.",
+		})
+	}
+	routes, diags := parseRoutes(diags, *routesP)
+	if len(routes) > 0 && (*splitByFileP || *emitDeclsP) {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Conflicting output options",
+			Detail:   "The --route option can't be used with --split-by-file or --emit-declarations.",
+		})
+	}
+	var declaredPattern *regexp.Regexp
+	if *declaredPatternP != "" {
+		var err error
+		declaredPattern, err = regexp.Compile(*declaredPatternP)
+		if err != nil {
 			diags = append(diags, tfconfig.Diagnostic{
 				Severity: tfconfig.DiagError,
-				Summary:  "JSON tfvars not supported",
-				Detail:   fmt.Sprintf("Can't read %s: only native syntax .tfvars files are supported.", varFilePath),
+				Summary:  "Invalid declared variable pattern",
+				Detail:   fmt.Sprintf("The --declared-pattern value %q is not a valid regular expression: %s.", *declaredPatternP, err),
 			})
-			continue
 		}
+	}
+	exitIfErrors(diags)
+
+	var moreDiags []tfconfig.Diagnostic
+	var data map[string]interface{}
+	if *dataP != "" {
+		data, moreDiags = loadDataFile(*dataP)
+		diags = append(diags, moreDiags...)
+		exitIfErrors(diags)
+	}
+	reader := &varFileReader{
+		Data:        data,
+		Strict:      *strictP,
+		ReadRetries: *readRetriesP,
+		SSHIdentity: *sshIdentityP,
+
+		NormalizeLiterals: *normalizeLiteralsP,
+		SkipNullInput:     *skipNullInputP,
+
+		FailOnParseWarning: *failOnParseWarningP,
+		BestEffort:         *bestEffortP,
+		InlineFiles:        *inlineFilesP,
+		FollowIncludes:     *followIncludesP,
+	}
+
+	loadStart := time.Now()
+	var mod *tfconfig.Module
+	switch {
+	case *moduleJSONP != "":
+		mod, moreDiags = loadModuleJSON(*moduleJSONP)
+	case modDir != "":
+		mod, moreDiags = loadModuleDir(modDir)
+		if *variablesOnlyP {
+			moreDiags = relaxNonVariableDiags(moreDiags, modDir)
+		}
+	default:
+		mod = &tfconfig.Module{
+			Variables: make(map[string]*tfconfig.Variable),
+		}
+	}
+	diags = append(diags, moreDiags...)
+	if len(*extraModulesP) > 0 && mod != nil {
+		primaryPath := modDir
+		if *moduleJSONP != "" {
+			primaryPath = *moduleJSONP
+		}
+		mods := []namedModule{{Path: primaryPath, Module: mod}}
+		for _, dir := range *extraModulesP {
+			extra, moreDiags := loadModuleDir(dir)
+			if *variablesOnlyP {
+				moreDiags = relaxNonVariableDiags(moreDiags, dir)
+			}
+			diags = append(diags, moreDiags...)
+			if extra != nil {
+				mods = append(mods, namedModule{Path: dir, Module: extra})
+			}
+		}
+		mod, diags = mergeModules(diags, mods, *modulePrecedenceP)
+	}
+	templateVars := make(map[string]bool)
+	if *templateVarsP != "" && mod != nil {
+		diags = appendTemplateVars(diags, mod, reader, *templateVarsP, templateVars)
+	}
+	if mod != nil {
+		for _, path := range *declaredFromFilesP {
+			diags = appendTemplateVars(diags, mod, reader, path, templateVars)
+		}
+	}
+	if *passAllowedP && mod != nil {
+		// The allowed variables are passed through by treating them as
+		// declared, albeit without any position or type constraint.
+		for _, name := range *allowUndeclaredP {
+			if _, exists := mod.Variables[name]; !exists {
+				mod.Variables[name] = &tfconfig.Variable{Name: name}
+			}
+		}
+	}
+	if *sensitivityFromStateP != "" && mod != nil {
+		diags = applyStateSensitivity(diags, mod, *sensitivityFromStateP)
+	}
+	if mod != nil && len(mod.Variables) == 0 {
+		detail := "The module declares no variables, so all of the given values will be discarded."
+		if modDir != "" {
+			detail = fmt.Sprintf("The module in %s declares no variables, so all of the given values will be discarded. This usually means that the wrong directory was given.", modDir)
+		}
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: diagInfo,
+			Summary:  "Module declares no variables",
+			Detail:   detail,
+		})
+	}
+	showTiming(*timingsP, "loading module", loadStart)
+	exitIfErrors(diags)
+	if *schemaOutP != "" && !*dryRunP {
+		diags = writeOutputFile(diags, *schemaOutP, buildVariableSchema(mod), outputOptions{})
+	}
 
-		varFileSrc, err := ioutil.ReadFile(varFilePath)
+	parseStart := time.Now()
+
+	// All of the sources of values are merged in a single ordered sequence,
+	// where later sources override earlier ones:
+	//   - TF_VAR_ environment variables, if --env is set
+	//   - terraform.tfvars and *.auto.tfvars files, if --auto-load is set
+	//   - content given with --inline-vars, in order
+	//   - tfvars files listed in the --files-from manifest, in order
+	//   - tfvars files given as arguments, in order
+	//   - tfvars files read from stdin, if --stdin-multi is set
+	//
+	// We parse all of the files before merging any of them, so that the
+	// result of the merge depends only on the order of the sources and
+	// not on the order in which the files happened to be read.
+	var sources []*filtervars.Source
+	if *envP {
+		var envSrc []byte
+		envSrc, diags = envVarsSource(diags, os.Environ(), mod)
+		vf, moreDiags := reader.Parse(envSrc, envVarsSourceFilename)
+		diags = append(diags, moreDiags...)
+		sources = append(sources, vf)
+	}
+	autoSources := make([]*filtervars.Source, len(autoLoadPaths))
+	for i, path := range autoLoadPaths {
+		autoSources[i], moreDiags = reader.Read(path)
+		diags = append(diags, moreDiags...)
+	}
+	sources = append(sources, autoSources...)
+	explicitStart := len(sources)
+	for _, content := range *inlineVarsP {
+		vf, moreDiags := reader.Load([]byte(content), inlineVarsSourceFilename, "hcl")
+		diags = append(diags, moreDiags...)
+		sources = append(sources, vf)
+	}
+	varFiles, varFileDiags := reader.ReadAll(varFilePaths, *parallelismP)
+	for _, moreDiags := range varFileDiags {
+		diags = append(diags, moreDiags...)
+		if capped, ok := capErrors(diags, *maxErrorsP); !ok {
+			exitWithDiags(capped)
+		}
+	}
+	if *bestEffortP && len(varFiles) > 0 {
+		anyRead := false
+		for _, vf := range varFiles {
+			if vf != nil {
+				anyRead = true
+				break
+			}
+		}
+		if !anyRead {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "No tfvars files could be read",
+				Detail:   "None of the given tfvars files could be read, so there are no values to filter.",
+			})
+		}
+	}
+	sources = append(sources, varFiles...)
+	if *stdinMultiP {
+		src, err := ioutil.ReadAll(os.Stdin)
 		if err != nil {
 			diags = append(diags, tfconfig.Diagnostic{
 				Severity: tfconfig.DiagError,
-				Summary:  "Failed to read input file",
-				Detail:   fmt.Sprintf("Can't read %s: %s.", varFilePath, err),
+				Summary:  "Failed to read stdin",
+				Detail:   fmt.Sprintf("Can't read tfvars files from stdin: %s.", err),
 			})
-			continue
+			exitWithDiags(diags)
+		}
+		for _, fragment := range splitStdinFragments(src) {
+			name, format := splitVarFileFormat(fragment.Name)
+			vf, moreDiags := reader.Load(fragment.Src, name, format)
+			diags = append(diags, moreDiags...)
+			sources = append(sources, vf)
 		}
+	}
+	if declaredPattern != nil {
+		declarePatternVariables(mod, sources, declaredPattern)
+	}
+	if *noAutoOverrideP {
+		diags = appendAutoOverrideDiags(diags, autoSources, sources[explicitStart:])
+	}
+	if *warnSensitiveOverridesP {
+		diags = appendSensitiveOverrideDiags(diags, mod, sources)
+	}
+
+	filterer := &filtervars.Filterer{
+		Module:          mod,
+		AllowUndeclared: make(map[string]bool, len(*allowUndeclaredP)),
+	}
+	for _, name := range *allowUndeclaredP {
+		filterer.AllowUndeclared[name] = true
+	}
+	aliases, diags := parseAliases(diags, *aliasesP, mod)
+	exitIfErrors(diags)
+	for _, alias := range aliases {
+		filterer.AllowUndeclared[alias.From] = true
+	}
+	if *coerceP {
+		filterer.Transform = coerceTransform(mod)
+	}
+	if *expandEnvP {
+		filterer.Variables, diags = envExpansionVariables(diags, sources, mod, os.Environ(), *expandEnvDefaultP, flag.CommandLine.Changed("expand-env-default"))
+		exitIfErrors(diags)
+	}
+	var nulls map[string]*hclsyntax.Attribute
+	if *dropNullsP {
+		nulls = dropNullDefinitions(mod, sources)
+	}
+	result, moreDiags := filterer.Filter(sources)
+	diags = append(diags, moreDiags...)
+	addDroppedNulls(result, nulls)
+	diags = applyAliases(diags, aliases, result, sources)
+	if *fixP {
+		exitIfErrors(diags)
+		diags = fixVarFiles(diags, mod, varFilePaths, varFiles, filterer.AllowUndeclared, *checkP, *dryRunP)
+		exitWithDiags(diags)
+	}
+	if *documentedOnlyP {
+		keepOnlyVariables(mod, result, "its variable has no description and --documented-only is set", variableDocumented)
+	}
+	switch *onlyP {
+	case "required":
+		keepOnlyVariables(mod, result, "its variable has a default value and --only=required is set", templateVarOr(templateVars, variableRequired))
+	case "optional":
+		keepOnlyVariables(mod, result, "its variable has no default value and --only=optional is set", templateVarOr(templateVars, variableOptional))
+	}
+	if *forResourceP != "" {
+		var referenced map[string]bool
+		referenced, diags = resourceVariables(diags, modDir, *forResourceP)
+		exitIfErrors(diags)
+		reason := fmt.Sprintf("%s doesn't refer to its variable", *forResourceP)
+		keepOnlyVariables(mod, result, reason, func(v *tfconfig.Variable) bool {
+			return referenced[v.Name]
+		})
+	}
+	if *normalizeNumbersP {
+		normalizeNumbers(result)
+	}
+	wantedVars := result.Names
+	if len(*priorityP) > 0 {
+		wantedVars = prioritizeNames(wantedVars, *priorityP)
+	}
+	attrs := result.Attrs
+	if *stableFromP != "" {
+		diags = applyStableOutput(diags, *stableFromP, attrs, result.Values)
+	}
 
-		varFile, hclDiags := hclwrite.ParseConfig(varFileSrc, varFilePath, hcl.Pos{Line: 1, Column: 1})
-		diags = appendHCLDiags(diags, hclDiags)
-		if hclDiags.HasErrors() {
+	for i, src := range sources {
+		if src == nil || len(result.SourceAttrs[i]) > 0 {
 			continue
 		}
+		detail := fmt.Sprintf("%s defines no variables at all.", src.Name)
+		if len(src.SyntaxBody.Attributes) > 0 {
+			detail = fmt.Sprintf("None of the variables defined in %s are declared, so it contributes nothing to the result.", src.Name)
+		}
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: diagInfo,
+			Summary:  "File contributes no variables",
+			Detail:   detail,
+		})
+	}
+	if *explainP != "" {
+		writeExplanation(os.Stdout, *explainP, mod, sources, result, wantedVars)
+		exitWithDiags(diags)
+	}
+	if *compareTerraformP {
+		writeTerraformComparison(os.Stdout, reader, modDir, mod, result, *autoLoadP, *envP)
+		exitWithDiags(diags)
+	}
+	if *checkTypesP {
+		diags = appendTypeCheckDiags(diags, mod, wantedVars, result.Values)
+	}
+	if *warnEmptyRequiredP {
+		diags = appendEmptyRequiredDiags(diags, mod, wantedVars, result.Values)
+	}
+	switch {
+	case *checkDefaultsMatchP:
+		diags = appendDefaultMatchDiags(diags, tfconfig.DiagError, mod, wantedVars, result.Values)
+	case *reportRedundantP:
+		diags = appendDefaultMatchDiags(diags, tfconfig.DiagWarning, mod, wantedVars, result.Values)
+	}
+	if *denyValuesP != "" {
+		diags = appendDeniedValueDiags(diags, *denyValuesP, wantedVars, result.Values)
+	}
+	if *failOnDropP {
+		diags = appendDroppedDiags(diags, mod, result.Dropped, *suggestDistanceP)
+	}
+	showTiming(*timingsP, "parsing tfvars files", parseStart)
+	exitIfErrors(diags)
+	if *summaryP && !*noSummaryP {
+		sourceCount := 0
+		for _, src := range sources {
+			if src != nil {
+				sourceCount++
+			}
+		}
+		showSummary(mod, result, sourceCount)
+	}
+	if *countOnlyP {
+		writeCounts(os.Stdout, mod, result, *formatP == "json")
+		exitWithDiags(diags)
+	}
+
+	if *requireWriteP && len(attrs) == 0 && !*emitDeclsP {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "No variables to write",
+			Detail:   "None of the given tfvars files define any of the module's variables, so the result would be empty.",
+		})
+		exitWithDiags(diags)
+	}
+	if (*minVarsP > 0 || *maxVarsP > 0) && !*emitDeclsP {
+		count := 0
+		for _, name := range wantedVars {
+			if _, ok := attrs[name]; ok {
+				count++
+			}
+		}
+		diags = appendVarCountDiags(diags, count, *minVarsP, *maxVarsP)
+		exitIfErrors(diags)
+	}
+
+	outOpts := outputOptions{
+		Compress: *compressP,
+		Format:   *fmtP || flag.CommandLine.Changed("indent") || *useTabsP,
+		Indent:   *indentP,
+		UseTabs:  *useTabsP,
+		EOL:      *eolP,
+
+		TrimSpace:   !*noTrimP,
+		ValidateCmd: *validateCmdP,
+	}
+
+	var comments map[string]string
+	if *annotateSourceP {
+		fromJSON := make(map[string]bool)
+		for _, src := range sources {
+			if src != nil && src.FromJSON {
+				fromJSON[src.Name] = true
+			}
+		}
+		comments = make(map[string]string, len(result.Values))
+		for name, attr := range result.Values {
+			comments[name] = sourceComment(attr, fromJSON[attr.SrcRange.Filename])
+		}
+	}
+
+	writeStart := time.Now()
+	if *positionsJSONP != "" && !*dryRunP {
+		diags = writeOutputFile(diags, *positionsJSONP, buildPositionsJSON(result.Definitions), outputOptions{})
+	}
+	if *snapshotP != "" && !*dryRunP {
+		diags = writeOutputFile(diags, *snapshotP, buildSnapshot(mod, result, wantedVars), outputOptions{})
+	}
+	if *provenanceP != "" && !*dryRunP {
+		diags = writeOutputFile(diags, *provenanceP, buildProvenance(result.Definitions, wantedVars), outputOptions{})
+	}
+	if *emitDeclsP {
+		if *dryRunP {
+			showDeclarationsDryRun(*outP, result.Undeclared)
+			exitWithDiags(diags)
+		}
+		diags = writeOutputFile(diags, *outP, buildDeclarationsFile(result.Undeclared), outOpts)
+		showTiming(*timingsP, "writing output", writeStart)
+		exitWithDiags(diags)
+	}
+	if *splitByFileP {
+		// In this mode each input file is filtered separately, rather than
+		// merging them all together, and so we write one output file per
+		// input file.
+		for i, vf := range sources {
+			if vf == nil {
+				// A file that couldn't be read with --best-effort has no
+				// output file of its own.
+				continue
+			}
+			outPath := filepath.Join(*outDirP, filepath.Base(vf.Name))
+			content := &outputContent{
+				Module: mod,
+				Names:  wantedVars,
+				Attrs:  result.SourceAttrs[i],
 
-		for name, attr := range varFile.Body().Attributes() {
-			if _, exists := wantedVarsSet[name]; !exists {
-				continue // ignore undeclared
+				WrapStrings: *wrapStringsP,
 			}
-			// If multiple files define the same variable, we'll override
-			// previous definitions here so that the last one in the sequence
-			// "wins", which is consistent with Terraform's own interpretation
-			// of multiple -var-file arguments.
-			attrs[name] = attr
+			if *annotateSourceP {
+				// Each output file is built from only its own input
+				// file, rather than from the merged result.
+				content.Comments = make(map[string]string, len(content.Attrs))
+				for _, attr := range vf.SyntaxBody.Attributes {
+					content.Comments[attr.Name] = sourceComment(attr, vf.FromJSON)
+				}
+			}
+			if *dryRunP {
+				showDryRun(outPath, content)
+				continue
+			}
+			var outF io.WriterTo
+			outF, diags = buildOutput(diags, *formatP, content)
+			exitIfErrors(diags)
+			diags = writeOutputFile(diags, outPath, outF, outOpts)
+		}
+		showTiming(*timingsP, "writing output", writeStart)
+		exitWithDiags(diags)
+	}
+
+	if len(routes) > 0 {
+		// Each route gets only the variables that actually have values,
+		// so that we don't create files that would be empty.
+		var kept []string
+		for _, name := range wantedVars {
+			if _, ok := attrs[name]; ok {
+				kept = append(kept, name)
+			}
+		}
+		byPath, paths := routeNames(routes, *outP, kept)
+		for _, path := range paths {
+			content := &outputContent{
+				Module:   mod,
+				Names:    byPath[path],
+				Attrs:    attrs,
+				Comments: comments,
+
+				WrapStrings: *wrapStringsP,
+			}
+			if *dryRunP {
+				showDryRun(path, content)
+				continue
+			}
+			var outF io.WriterTo
+			outF, diags = buildOutput(diags, *formatP, content)
+			exitIfErrors(diags)
+			diags = writeOutputFile(diags, path, outF, outOpts)
 		}
+		showTiming(*timingsP, "writing output", writeStart)
+		exitWithDiags(diags)
+	}
+
+	content := &outputContent{
+		Module:   mod,
+		Names:    wantedVars,
+		Attrs:    attrs,
+		Comments: comments,
+
+		WrapStrings: *wrapStringsP,
+	}
+	if *groupBySourceP {
+		content.Sections = sourceSections(wantedVars, result.Values, sources)
+	}
+	if *dryRunP {
+		showDryRun(*outP, content)
+		exitWithDiags(diags)
 	}
+	outF, diags := buildOutput(diags, *formatP, content)
 	exitIfErrors(diags)
+	diags = writeOutputFile(diags, *outP, outF, outOpts)
+	showTiming(*timingsP, "writing output", writeStart)
+	exitWithDiags(diags)
+}
 
-	outF := hclwrite.NewEmptyFile()
-	outBody := outF.Body()
-	for _, name := range wantedVars {
-		attr, ok := attrs[name]
-		if !ok {
-			continue
+// prioritizeNames returns a copy of the given names where those that are
+// also in the given priority list come first, in the order of that list,
+// followed by the others in their original order.
+func prioritizeNames(names []string, priority []string) []string {
+	rank := make(map[string]int, len(priority))
+	for i, name := range priority {
+		if _, exists := rank[name]; !exists {
+			rank[name] = i
 		}
+	}
 
-		// We're not going to do any further wrangling of the attributes, so
-		// for simplicity we'll just paste them in as unstructured tokens
-		// to our output file. That avoids book-keeping around detaching and
-		// re-attaching, because the sequence of tokens will be reconstructed
-		// here.
-		outBody.AppendUnstructuredTokens(attr.BuildTokens(nil))
+	ret := make([]string, len(names))
+	copy(ret, names)
+	sort.SliceStable(ret, func(i, j int) bool {
+		ri, iOK := rank[ret[i]]
+		rj, jOK := rank[ret[j]]
+		switch {
+		case iOK && jOK:
+			return ri < rj
+		default:
+			return iOK && !jOK
+		}
+	})
+	return ret
+}
+
+// appendVarCountDiags returns an error if the given number of variables to
+// be written is less than min or, if max is greater than zero, more than
+// max, for --min-vars and --max-vars.
+func appendVarCountDiags(diags []tfconfig.Diagnostic, count, min, max int) []tfconfig.Diagnostic {
+	switch {
+	case count < min:
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Too few variables",
+			Detail:   fmt.Sprintf("The result would contain %d variables, but --min-vars requires at least %d.", count, min),
+		})
+	case max > 0 && count > max:
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Too many variables",
+			Detail:   fmt.Sprintf("The result would contain %d variables, but --max-vars allows at most %d.", count, max),
+		})
 	}
+	return diags
+}
 
-	var outWr *os.File
-	switch *outP {
-	case "-":
-		outWr = os.Stdout
-	default:
-		var err error
-		outWr, err = os.Create(*outP)
-		if err != nil {
+// appendSplitByFileDiags checks that the options and input files are
+// suitable for --split-by-file mode, where the output filenames are derived
+// from the input filenames.
+func appendSplitByFileDiags(diags []tfconfig.Diagnostic, outDir, out string, varFilePaths []string) []tfconfig.Diagnostic {
+	if outDir == "" {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Output directory required",
+			Detail:   "The --split-by-file option requires --out-dir, to specify where to write the filtered files.",
+		})
+	}
+	if out != "-" {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Conflicting output options",
+			Detail:   "The --out option can't be used with --split-by-file, because there is a separate output file for each input file.",
+		})
+	}
+
+	seen := make(map[string]string, len(varFilePaths))
+	for _, varFilePath := range varFilePaths {
+		path, _ := splitVarFileFormat(varFilePath)
+		base := filepath.Base(path)
+		if prev, exists := seen[base]; exists {
 			diags = append(diags, tfconfig.Diagnostic{
 				Severity: tfconfig.DiagError,
-				Summary:  "Failed to open output file",
-				Detail:   fmt.Sprintf("Can't create %s: %s.", *outP, err),
+				Summary:  "Conflicting output filenames",
+				Detail:   fmt.Sprintf("Both %s and %s would be written to %s in the output directory.", prev, varFilePath, base),
 			})
-			exitWithDiags(diags)
+			continue
 		}
+		seen[base] = varFilePath
+	}
+
+	return diags
+}
+
+// appendTfvarsBlockDiags reports any blocks found in the given tfvars file
+// body, since only attribute assignments are meaningful in tfvars files. A
+// block here is usually a variable declaration pasted into the wrong file.
+func appendTfvarsBlockDiags(diags []tfconfig.Diagnostic, body *hclsyntax.Body, strict bool) []tfconfig.Diagnostic {
+	severity := tfconfig.DiagWarning
+	if strict {
+		severity = tfconfig.DiagError
+	}
+
+	for _, block := range body.Blocks {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: severity,
+			Summary:  "Unexpected block in tfvars file",
+			Detail:   fmt.Sprintf("A tfvars file may contain only attribute assignments, so this %q block will be ignored.", block.Type),
+			Pos: &tfconfig.SourcePos{
+				Filename: block.TypeRange.Filename,
+				Line:     block.TypeRange.Start.Line,
+			},
+		})
 	}
 
-	_, err := outF.WriteTo(outWr)
-	if err != nil {
+	return diags
+}
+
+// appendDroppedDiags returns an error for each of the given dropped values.
+// For a value that was dropped because its variable isn't declared, the
+// error suggests a declared variable with a similar name, if there is one
+// within the given edit distance.
+func appendDroppedDiags(diags []tfconfig.Diagnostic, mod *tfconfig.Module, dropped []filtervars.DroppedValue, suggestDistance int) []tfconfig.Diagnostic {
+	for _, d := range dropped {
+		pos := d.Pos
+		detail := fmt.Sprintf("The value for %q would not be included in the output, because %s.", d.Name, d.Reason)
+		if _, declared := mod.Variables[d.Name]; !declared {
+			if suggestion := suggestVariable(mod, d.Name, suggestDistance); suggestion != "" {
+				detail += fmt.Sprintf(" Did you mean %q?", suggestion)
+			}
+		}
 		diags = append(diags, tfconfig.Diagnostic{
 			Severity: tfconfig.DiagError,
-			Summary:  "Failed to write to output file",
-			Detail:   fmt.Sprintf("Error writing to %s: %s.", *outP, err),
+			Summary:  "Value would be dropped",
+			Detail:   detail,
+			Pos:      &pos,
 		})
-		exitWithDiags(diags)
 	}
+	return diags
+}
 
-	exitWithDiags(diags)
+// capErrors checks whether the given diagnostics include more than max
+// errors. If so, it returns false along with a copy of the diagnostics that
+// includes only the first max errors and a final note that there were more.
+//
+// A max of zero or less means there is no limit.
+func capErrors(diags []tfconfig.Diagnostic, max int) ([]tfconfig.Diagnostic, bool) {
+	if max <= 0 {
+		return diags, true
+	}
+
+	errCount := 0
+	for i, diag := range diags {
+		if diag.Severity != tfconfig.DiagError {
+			continue
+		}
+		errCount++
+		if errCount > max {
+			ret := make([]tfconfig.Diagnostic, 0, i+1)
+			ret = append(ret, diags[:i]...)
+			ret = append(ret, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Too many errors",
+				Detail:   fmt.Sprintf("Reached the error limit of %d set by --max-errors, so there may be more problems that were not reported.", max),
+			})
+			return ret, false
+		}
+	}
+	return diags, true
+}
+
+// resultCounts are some statistics about a filtering result, for --summary
+// and --count-only.
+type resultCounts struct {
+	Declared        int `json:"declared"`
+	Provided        int `json:"provided"`
+	Undeclared      int `json:"undeclared"`
+	Dropped         int `json:"dropped"`
+	MissingRequired int `json:"missing_required"`
+	Overridden      int `json:"overridden"`
+}
+
+// countResult computes the counts for the given result.
+func countResult(mod *tfconfig.Module, result *filtervars.Result) resultCounts {
+	ret := resultCounts{
+		Declared:   len(result.Names),
+		Provided:   len(result.Attrs),
+		Undeclared: len(result.Undeclared),
+		Dropped:    len(result.Dropped),
+	}
+	for _, name := range result.Names {
+		if _, ok := result.Attrs[name]; !ok && mod.Variables[name].Required {
+			ret.MissingRequired++
+		}
+		if defs := result.Definitions[name]; len(defs) > 1 {
+			ret.Overridden += len(defs) - 1
+		}
+	}
+	return ret
+}
+
+// showSummary reports to stderr a single line summarizing the given result,
+// which was produced from the given number of sources. Each tfvars file is a
+// source, as are the environment variables, each --inline-vars argument, and
+// each file read with --stdin-multi.
+func showSummary(mod *tfconfig.Module, result *filtervars.Result, sourceCount int) {
+	counts := countResult(mod, result)
+	fmt.Fprintf(
+		os.Stderr, "filtered %d/%d declared variables from %d sources (%d dropped, %d missing required)\n",
+		counts.Provided, counts.Declared, sourceCount, counts.Dropped, counts.MissingRequired,
+	)
+}
+
+// writeCounts writes the counts for the given result to the given writer,
+// either as JSON or as a single line of space-separated key=value pairs.
+func writeCounts(w io.Writer, mod *tfconfig.Module, result *filtervars.Result, asJSON bool) {
+	counts := countResult(mod, result)
+	if asJSON {
+		src, _ := json.Marshal(counts) // can't fail for a struct of ints
+		fmt.Fprintf(w, "%s\n", src)
+		return
+	}
+	fmt.Fprintf(
+		w, "declared=%d provided=%d undeclared=%d dropped=%d missing_required=%d overridden=%d\n",
+		counts.Declared, counts.Provided, counts.Undeclared, counts.Dropped, counts.MissingRequired, counts.Overridden,
+	)
+}
+
+// showTiming reports to stderr how long has passed since the given start
+// time, if timing reports are enabled.
+func showTiming(enabled bool, phase string, start time.Time) {
+	if !enabled {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Timing: %s took %s\n", phase, time.Since(start))
 }
 
 func showDiags(diags []tfconfig.Diagnostic) {
+	if diagnosticsFormat == "github" {
+		showGitHubDiags(diags)
+		return
+	}
+
 	for _, diag := range diags {
 		var prefixStr string
 		switch diag.Severity {
@@ -156,13 +983,51 @@ func showDiags(diags []tfconfig.Diagnostic) {
 			prefixStr = "Error: "
 		case tfconfig.DiagWarning:
 			prefixStr = "Warning: "
+		case diagInfo:
+			if !verbose {
+				continue
+			}
+			prefixStr = "Note: "
 		}
 
 		if diag.Pos != nil {
 			prefixStr = fmt.Sprintf("%s (%s:%d) ", prefixStr, diag.Pos.Filename, diag.Pos.Line)
 		}
 
-		fmt.Fprintf(os.Stderr, "%s%s; %s", prefixStr, diag.Summary, diag.Detail)
+		fmt.Fprintf(os.Stderr, "%s%s; %s\n", prefixStr, diag.Summary, diag.Detail)
+	}
+}
+
+// showGitHubDiags writes the given diagnostics as GitHub Actions workflow
+// commands, so that they appear as annotations on the files they relate to.
+// The runner recognizes commands in stderr as well as stdout, so they're
+// written to stderr as usual to keep them separate from the output.
+func showGitHubDiags(diags []tfconfig.Diagnostic) {
+	escapeData := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	escapeProperty := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+
+	for _, diag := range diags {
+		var command string
+		switch diag.Severity {
+		case tfconfig.DiagError:
+			command = "error"
+		case tfconfig.DiagWarning:
+			command = "warning"
+		case diagInfo:
+			if !verbose {
+				continue
+			}
+			command = "notice"
+		}
+
+		props := []string{"title=" + escapeProperty.Replace(diag.Summary)}
+		if diag.Pos != nil {
+			props = append(props,
+				"file="+escapeProperty.Replace(diag.Pos.Filename),
+				fmt.Sprintf("line=%d", diag.Pos.Line),
+			)
+		}
+		fmt.Fprintf(os.Stderr, "::%s %s::%s\n", command, strings.Join(props, ","), escapeData.Replace(diag.Detail))
 	}
 }
 
@@ -214,5 +1079,6 @@ func appendHCLDiags(diags []tfconfig.Diagnostic, hclDiags hcl.Diagnostics) []tfc
 }
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "Usage: terraform-filter-vars <module-dir> [tfvars-files...]\n\nReads the given tfvars files and produces output in tfvars format containing only definitions for variables declared in the given module.\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: terraform-filter-vars <module-dir> [tfvars-files...]\n       terraform-filter-vars --module-json=<file> [tfvars-files...]\n       terraform-filter-vars --template-vars=<file> [tfvars-files...]\n       terraform-filter-vars --declared-from-files=<file>,... [tfvars-files...]\n\nReads the given tfvars files and produces output in tfvars format containing only definitions for variables declared in the given module.\n\nValues are taken from the following sources in order, with later sources overriding earlier ones:\n  1. TF_VAR_ environment variables, if --env is set\n  2. terraform.tfvars and *.auto.tfvars files in the module directory, if --auto-load is set\n  3. Content given with --inline-vars, in the order given\n  4. Files listed in the --files-from manifest, in the order listed\n  5. Files given as arguments, in the order given\n  6. Files read from stdin, if --stdin-multi is set\n\nA directory given as a tfvars file stands for all of the .tfvars and .tfvars.json files directly inside it, in lexical order, or with --recursive all of those in its subdirectories too.\n\nDefault option values can be set in a %s file in the module directory or the current working directory.\n\nOptions:\n", configFilename)
+	flag.PrintDefaults()
 }
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/apparentlymart/terraform-filter-vars/internal/filtertest"
+)
+
+// mainTestEnv is set in the environment of the processes that runMain
+// starts, so that TestMain runs the program instead of the tests.
+const mainTestEnv = "TERRAFORM_FILTER_VARS_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mainTestEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// cliTest describes a single run of the program, for runCLITests.
+type cliTest struct {
+	// Files are written into an empty working directory before the run,
+	// keyed by their paths relative to it.
+	Files map[string]string
+
+	Args  []string
+	Env   []string
+	Stdin string
+
+	// Setup, if set, is called with the path of the working directory
+	// after the files are written and before the run.
+	Setup func(t *testing.T, dir string)
+
+	// WantExit is the expected exit status, and WantStdout the exact
+	// expected content of stdout. Each of WantStderr must appear somewhere
+	// in stderr, and none of DontWantStderr may. Each of WantFiles must
+	// exist with the given content after the run.
+	WantExit       int
+	WantStdout     string
+	WantStderr     []string
+	DontWantStderr []string
+	WantFiles      map[string]string
+
+	// Check, if set, is called after the other checks with the path of
+	// the working directory, to make any checks specific to the test.
+	Check func(t *testing.T, dir string)
+}
+
+// runCLITests runs the program once for each of the given tests, each in a
+// new working directory, and checks its results.
+func runCLITests(t *testing.T, tests map[string]cliTest) {
+	t.Helper()
+
+	// Map iteration order varies, so we sort the names to make the order
+	// of any failures predictable.
+	names := make([]string, 0, len(tests))
+	for name := range tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		test := tests[name]
+		t.Run(name, func(t *testing.T) {
+			dir := filtertest.TempDir(t)
+			defer os.RemoveAll(dir)
+			for path, content := range test.Files {
+				filtertest.WriteFile(t, dir, path, content)
+			}
+			if test.Setup != nil {
+				test.Setup(t, dir)
+			}
+
+			stdout, stderr, exit := runMain(t, dir, test.Stdin, test.Env, test.Args...)
+			if exit != test.WantExit {
+				t.Errorf("wrong exit status %d; want %d\nstderr:\n%s", exit, test.WantExit, stderr)
+			}
+			if stdout != test.WantStdout {
+				t.Errorf("wrong stdout\ngot:\n%s\nwant:\n%s", stdout, test.WantStdout)
+			}
+			for _, want := range test.WantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr doesn't include %q\nstderr:\n%s", want, stderr)
+				}
+			}
+			for _, dontWant := range test.DontWantStderr {
+				if strings.Contains(stderr, dontWant) {
+					t.Errorf("stderr includes %q\nstderr:\n%s", dontWant, stderr)
+				}
+			}
+			for path, want := range test.WantFiles {
+				got, err := ioutil.ReadFile(filepath.Join(dir, path))
+				if err != nil {
+					t.Errorf("can't read %s: %s", path, err)
+					continue
+				}
+				if string(got) != want {
+					t.Errorf("wrong content in %s\ngot:\n%s\nwant:\n%s", path, got, want)
+				}
+			}
+			if test.Check != nil {
+				test.Check(t, dir)
+			}
+		})
+	}
+}
+
+// runMain runs the program with the given arguments in the given working
+// directory, with the given stdin content and environment variables in
+// addition to those of the test process, other than any TF_VAR_ variables.
+// It returns the content of stdout and stderr, and the exit status.
+func runMain(t *testing.T, dir, stdin string, env []string, args ...string) (string, string, int) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(stdin)
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "TF_VAR_") {
+			cmd.Env = append(cmd.Env, v)
+		}
+	}
+	cmd.Env = append(cmd.Env, mainTestEnv+"=1")
+	cmd.Env = append(cmd.Env, env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	exit := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exit = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("can't run: %s", err)
+	}
+	return stdout.String(), stderr.String(), exit
+}
+
+// testModuleTF declares the variables of the module that most of the CLI
+// tests use, in mod/variables.tf: a required variable, an optional one,
+// and a sensitive one.
+const testModuleTF = `variable "region" {}
+
+variable "name" {
+  default = "web"
+}
+
+variable "token" {
+  sensitive = true
+}
+`
+
+// withTestModule returns the given files along with the testModuleTF
+// module.
+func withTestModule(files map[string]string) map[string]string {
+	ret := map[string]string{"mod/variables.tf": testModuleTF}
+	for path, content := range files {
+		ret[path] = content
+	}
+	return ret
+}
+
+func TestCLISummary(t *testing.T) {
+	files := withTestModule(map[string]string{
+		"mod/terraform.tfvars": "region = \"us-east-1\"\n",
+		"a.tfvars":             "name = \"api\"\nextra = 1\n",
+		"b.tfvars":             "region = \"eu-west-1\"\n",
+	})
+
+	runCLITests(t, map[string]cliTest{
+		"files": {
+			Files:      files,
+			Args:       []string{"--summary", "-o", "out.tfvars", "mod", "a.tfvars", "b.tfvars"},
+			WantStderr: []string{"filtered 2/3 declared variables from 2 sources (1 dropped, 1 missing required)\n"},
+		},
+		"auto-loaded file only": {
+			Files:      files,
+			Args:       []string{"--summary", "--auto-load", "-o", "out.tfvars", "mod"},
+			WantStderr: []string{"filtered 1/3 declared variables from 1 sources (0 dropped, 1 missing required)\n"},
+		},
+		"all kinds of source": {
+			Files: func() map[string]string {
+				ret := withTestModule(files)
+				ret["files.txt"] = "a.tfvars\n"
+				return ret
+			}(),
+			Env:        []string{"TF_VAR_token=s3cret"},
+			Stdin:      "# --- file: c.tfvars ---\nname = \"from stdin\"\n",
+			Args:       []string{"--summary", "--env", "--auto-load", "--inline-vars", "name = \"inline\"", "--files-from", "files.txt", "--stdin-multi", "-o", "out.tfvars", "mod", "b.tfvars"},
+			WantStderr: []string{"filtered 3/3 declared variables from 6 sources (1 dropped, 0 missing required)\n"},
+		},
+		"unreadable file": {
+			Files:      files,
+			Args:       []string{"--summary", "--best-effort", "-o", "out.tfvars", "mod", "a.tfvars", "missing.tfvars"},
+			WantStderr: []string{"filtered 1/3 declared variables from 1 sources (1 dropped, 2 missing required)\n"},
+		},
+		"no summary": {
+			Files:          files,
+			Args:           []string{"--summary", "--no-summary", "-o", "out.tfvars", "mod", "a.tfvars"},
+			DontWantStderr: []string{"filtered"},
+		},
+	})
+}
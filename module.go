@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// appendTemplateVars adds a declaration to the given module for each of the
+// variables defined in the given tfvars file, unless the module already has
+// its own declaration of the same name, recording the names of the added
+// declarations in the given set.
+//
+// These declarations come from values rather than from variable blocks, so
+// they have no type constraint, description, or default. The type checks
+// therefore accept any value for them, and they're used as-is by --coerce.
+// There's also no way to know whether they're required, so callers must use
+// templateVarOr to keep them under --only, rather than taking the lack of a
+// default to mean that they're required.
+func appendTemplateVars(diags []tfconfig.Diagnostic, mod *tfconfig.Module, reader *varFileReader, path string, templateVars map[string]bool) []tfconfig.Diagnostic {
+	vf, moreDiags := reader.Read(path)
+	diags = append(diags, moreDiags...)
+	if vf == nil {
+		return diags
+	}
+
+	if mod.Variables == nil {
+		mod.Variables = make(map[string]*tfconfig.Variable)
+	}
+	for name, attr := range vf.SyntaxBody.Attributes {
+		if _, exists := mod.Variables[name]; exists {
+			continue
+		}
+		mod.Variables[name] = &tfconfig.Variable{
+			Name: name,
+			Pos: tfconfig.SourcePos{
+				Filename: attr.NameRange.Filename,
+				Line:     attr.NameRange.Start.Line,
+			},
+		}
+		templateVars[name] = true
+	}
+	return diags
+}
+
+// loadModuleDir loads the module in the given directory, first checking
+// that the directory exists so that we can return a clearer error than
+// tfconfig.LoadModule would in that case.
+func loadModuleDir(dir string) (*tfconfig.Module, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Module directory not found",
+			Detail:   fmt.Sprintf("The module directory %s does not exist.", dir),
+		})
+		return nil, diags
+	case err != nil:
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Can't access module directory",
+			Detail:   fmt.Sprintf("Can't read %s: %s.", dir, err),
+		})
+		return nil, diags
+	case !info.IsDir():
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Module path is not a directory",
+			Detail:   fmt.Sprintf("The module path %s is a file, but it must be the directory containing the module's .tf files.", dir),
+		})
+		return nil, diags
+	}
+
+	mod, moreDiags := tfconfig.LoadModule(dir)
+	diags = append(diags, moreDiags...)
+	return mod, diags
+}
+
+// loadModuleJSON reads a module description previously produced by
+// terraform-config-inspect's JSON output mode.
+func loadModuleJSON(filename string) (*tfconfig.Module, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Failed to read module JSON",
+			Detail:   fmt.Sprintf("Can't read %s: %s.", filename, err),
+		})
+		return nil, diags
+	}
+
+	var mod tfconfig.Module
+	err = json.Unmarshal(src, &mod)
+	if err != nil {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Invalid module JSON",
+			Detail:   fmt.Sprintf("Can't decode %s: %s.", filename, err),
+		})
+		return nil, diags
+	}
+
+	// The JSON serialization includes the diagnostics that were produced
+	// when the module was originally inspected, so we'll return those too
+	// in order to behave the same as if we'd loaded the module directly.
+	diags = append(diags, mod.Diagnostics...)
+	return &mod, diags
+}
+
+// relaxNonVariableDiags returns a copy of the given module loading
+// diagnostics where any error that doesn't relate to a variable block is
+// downgraded to a warning, so that problems elsewhere in a module don't
+// prevent filtering against its variables.
+//
+// Errors that have no source position, or that are in JSON-syntax files
+// whose block ranges we can't determine, are left unchanged.
+func relaxNonVariableDiags(diags []tfconfig.Diagnostic, dir string) []tfconfig.Diagnostic {
+	varRanges := variableBlockRanges(dir)
+
+	ret := make([]tfconfig.Diagnostic, len(diags))
+	for i, diag := range diags {
+		ret[i] = diag
+		if diag.Severity != tfconfig.DiagError || diag.Pos == nil {
+			continue
+		}
+		if strings.HasSuffix(diag.Pos.Filename, ".json") {
+			continue
+		}
+
+		inVariable := false
+		for _, rng := range varRanges[diag.Pos.Filename] {
+			if diag.Pos.Line >= rng.Start.Line && diag.Pos.Line <= rng.End.Line {
+				inVariable = true
+				break
+			}
+		}
+		if !inVariable {
+			ret[i].Severity = tfconfig.DiagWarning
+		}
+	}
+	return ret
+}
+
+// variableBlockRanges finds the source ranges of all of the variable blocks
+// in the native syntax configuration files in the given directory, keyed
+// by filename.
+func variableBlockRanges(dir string) map[string][]hcl.Range {
+	ret := make(map[string][]hcl.Range)
+
+	filenames, _ := filepath.Glob(filepath.Join(dir, "*.tf"))
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+
+		// We ignore errors here because we're just looking for whatever
+		// variable blocks the parser was able to recover, and tfconfig
+		// will already have reported any errors.
+		f, _ := hclsyntax.ParseConfig(src, filename, hcl.Pos{Line: 1, Column: 1})
+		body, ok := f.Body.(*hclsyntax.Body)
+		if !ok {
+			continue
+		}
+		for _, block := range body.Blocks {
+			if block.Type == "variable" {
+				ret[filename] = append(ret[filename], block.Range())
+			}
+		}
+	}
+
+	return ret
+}
+
+// namedModule is a loaded module along with the path it was loaded from.
+type namedModule struct {
+	Path   string
+	Module *tfconfig.Module
+}
+
+// mergeModules combines the variable declarations of the given modules into
+// a single module, which is otherwise a copy of the first one.
+//
+// When more than one module declares the same variable, the declaration
+// from the earliest module in the given precedence list wins, with modules
+// not in that list taking precedence in the order given after those that
+// are. An informational diagnostic notes any conflicts in the type
+// constraint or sensitivity of such declarations.
+func mergeModules(diags []tfconfig.Diagnostic, mods []namedModule, precedence []string) (*tfconfig.Module, []tfconfig.Diagnostic) {
+	ordered := make([]namedModule, 0, len(mods))
+	used := make([]bool, len(mods))
+	for _, path := range precedence {
+		found := false
+		for i, mod := range mods {
+			if !used[i] && filepath.Clean(mod.Path) == filepath.Clean(path) {
+				ordered = append(ordered, mod)
+				used[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Unknown module in precedence list",
+				Detail:   fmt.Sprintf("The --module-precedence option lists %s, which is not one of the modules being filtered against.", path),
+			})
+		}
+	}
+	for i, mod := range mods {
+		if !used[i] {
+			ordered = append(ordered, mod)
+		}
+	}
+
+	merged := *mods[0].Module
+	merged.Variables = make(map[string]*tfconfig.Variable)
+	from := make(map[string]string)
+	for _, mod := range ordered {
+		for name, v := range mod.Module.Variables {
+			existing, exists := merged.Variables[name]
+			if !exists {
+				merged.Variables[name] = v
+				from[name] = mod.Path
+				continue
+			}
+			if existing.Type != v.Type || existing.Sensitive != v.Sensitive {
+				diags = append(diags, tfconfig.Diagnostic{
+					Severity: diagInfo,
+					Summary:  "Conflicting variable declarations",
+					Detail:   fmt.Sprintf("Variable %q is declared differently in %s and %s, so the declaration from %s is used.", name, from[name], mod.Path, from[name]),
+					Pos:      &v.Pos,
+				})
+			}
+		}
+	}
+	return &merged, diags
+}
+
+// applyStateSensitivity marks as sensitive each variable in the given module
+// that has the same name as a root module output that the given Terraform
+// state file records as sensitive. Variables already marked as sensitive in
+// the module stay that way.
+//
+// Terraform doesn't record variables in state, so a sensitive output of the
+// same name is the best available sign that a value was treated as
+// sensitive at apply time.
+func applyStateSensitivity(diags []tfconfig.Diagnostic, mod *tfconfig.Module, filename string) []tfconfig.Diagnostic {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Failed to read state file",
+			Detail:   fmt.Sprintf("Can't read %s: %s.", filename, err),
+		})
+	}
+
+	var state struct {
+		Version int `json:"version"`
+		Outputs map[string]struct {
+			Sensitive bool `json:"sensitive"`
+		} `json:"outputs"`
+	}
+	if err := json.Unmarshal(src, &state); err != nil {
+		return append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Invalid state file",
+			Detail:   fmt.Sprintf("Can't decode %s: %s.", filename, err),
+		})
+	}
+	if state.Version < 4 {
+		return append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Unsupported state file version",
+			Detail:   fmt.Sprintf("The state in %s uses format version %d, but only version 4 and later, as written by Terraform 0.12 and later, are supported.", filename, state.Version),
+		})
+	}
+
+	for name, output := range state.Outputs {
+		if v, ok := mod.Variables[name]; ok && output.Sensitive {
+			v.Sensitive = true
+		}
+	}
+	return diags
+}
+
+// declarePatternVariables adds a declaration to the given module for each
+// variable defined in the given sources whose name matches the given
+// pattern, for --declared-pattern. As with --pass-allowed, the declarations
+// have no position or type constraint.
+func declarePatternVariables(mod *tfconfig.Module, sources []*filtervars.Source, pattern *regexp.Regexp) {
+	for _, src := range sources {
+		if src == nil {
+			continue
+		}
+		for name := range src.SyntaxBody.Attributes {
+			if _, exists := mod.Variables[name]; !exists && pattern.MatchString(name) {
+				mod.Variables[name] = &tfconfig.Variable{Name: name}
+			}
+		}
+	}
+}
+
+// keepOnlyVariables removes from the given result all of the variables whose
+// declarations in the module don't satisfy the given predicate. Each value
+// that's removed is added to the result's dropped values with the given
+// reason, which can follow "because" in a sentence.
+func keepOnlyVariables(mod *tfconfig.Module, result *filtervars.Result, reason string, keep func(v *tfconfig.Variable) bool) {
+	names := result.Names[:0]
+	for _, name := range result.Names {
+		if v := mod.Variables[name]; v != nil && keep(v) {
+			names = append(names, name)
+			continue
+		}
+		if attr, ok := result.Values[name]; ok {
+			result.Dropped = append(result.Dropped, filtervars.NewDroppedValue(attr, reason))
+		}
+		delete(result.Attrs, name)
+		delete(result.Values, name)
+		delete(result.Definitions, name)
+		for _, attrs := range result.SourceAttrs {
+			delete(attrs, name)
+		}
+	}
+	result.Names = names
+}
+
+// variableDocumented is a predicate for keepOnlyVariables that accepts
+// variables that have a description.
+func variableDocumented(v *tfconfig.Variable) bool {
+	return v.Description != ""
+}
+
+// variableRequired is a predicate for keepOnlyVariables that accepts
+// variables that have no default value.
+func variableRequired(v *tfconfig.Variable) bool {
+	return v.Required
+}
+
+// templateVarOr returns a predicate for keepOnlyVariables that accepts the
+// variables declared by appendTemplateVars, whose names are in the given set,
+// along with any that the given predicate accepts.
+func templateVarOr(templateVars map[string]bool, keep func(v *tfconfig.Variable) bool) func(v *tfconfig.Variable) bool {
+	return func(v *tfconfig.Variable) bool {
+		return templateVars[v.Name] || keep(v)
+	}
+}
+
+// variableOptional is a predicate for keepOnlyVariables that accepts
+// variables that have a default value.
+func variableOptional(v *tfconfig.Variable) bool {
+	return !v.Required
+}
@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestCLIModule(t *testing.T) {
+	runCLITests(t, map[string]cliTest{
+		"JSON declarations": {
+			Files: map[string]string{
+				"mod/variables.tf":      `variable "native" {}`,
+				"mod/variables.tf.json": `{"variable": {"from_json": {"default": "x"}}}`,
+				"in.tfvars":             "native = \"a\"\nfrom_json = \"b\"\nundeclared = \"c\"\n",
+			},
+			Args:       []string{"mod", "in.tfvars"},
+			WantStdout: "from_json = \"b\"\nnative    = \"a\"\n",
+		},
+		"JSON declarations are optional": {
+			Files: map[string]string{
+				"mod/variables.tf":      `variable "native" {}`,
+				"mod/variables.tf.json": `{"variable": {"from_json": {"default": "x"}}}`,
+				"in.tfvars":             "native = \"a\"\nfrom_json = \"b\"\n",
+			},
+			Args:       []string{"--only", "required", "mod", "in.tfvars"},
+			WantStdout: "native = \"a\"\n",
+		},
+		"no declarations": {
+			Files: map[string]string{
+				"mod/main.tf": "locals {}\n",
+				"in.tfvars":   "native = \"a\"\n",
+			},
+			Args:       []string{"--verbose", "mod", "in.tfvars"},
+			WantStderr: []string{"Note: Module declares no variables; The module in mod declares no variables"},
+		},
+	})
+}
+
+func TestCLITemplateVars(t *testing.T) {
+	files := map[string]string{
+		"tmpl.tfvars": "region = \"x\"\nsize = 1\n",
+		"more.tfvars": "name = \"y\"\n",
+		"in.tfvars":   "region = \"us-east-1\"\nname = \"api\"\nsize = [1, 2]\n",
+	}
+	const templated = "region = \"us-east-1\"\nsize   = [1, 2]\n"
+
+	runCLITests(t, map[string]cliTest{
+		"template names": {
+			Files:      files,
+			Args:       []string{"--template-vars", "tmpl.tfvars", "in.tfvars"},
+			WantStdout: templated,
+		},
+		"several files": {
+			Files:      files,
+			Args:       []string{"--declared-from-files", "tmpl.tfvars,more.tfvars", "in.tfvars"},
+			WantStdout: "name   = \"api\"\nregion = \"us-east-1\"\nsize   = [1, 2]\n",
+		},
+		"kept with --only=required": {
+			// The template doesn't say whether its variables are
+			// required, so --only doesn't apply to them.
+			Files:      files,
+			Args:       []string{"--template-vars", "tmpl.tfvars", "--only", "required", "in.tfvars"},
+			WantStdout: templated,
+		},
+		"kept with --only=optional": {
+			Files:      files,
+			Args:       []string{"--template-vars", "tmpl.tfvars", "--only", "optional", "in.tfvars"},
+			WantStdout: templated,
+		},
+		"no type constraint": {
+			// The template's value for size is a number, but that doesn't
+			// constrain the type of other values for it.
+			Files:      files,
+			Args:       []string{"--template-vars", "tmpl.tfvars", "--check-types", "in.tfvars"},
+			WantStdout: templated,
+		},
+		"other names dropped": {
+			Files:      files,
+			Args:       []string{"--template-vars", "tmpl.tfvars", "--fail-on-drop", "in.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"Error:  (in.tfvars:2) Value would be dropped; The value for \"name\" would not be included in the output, because it is not declared."},
+		},
+	})
+}
@@ -0,0 +1,1003 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// outputContent is the content to be written out in one of the output
+// formats.
+type outputContent struct {
+	Module *tfconfig.Module
+
+	// Names is the order in which the variables should appear. Any name
+	// that doesn't have a corresponding attribute is skipped.
+	Names []string
+	Attrs map[string]*hclwrite.Attribute
+
+	// Comments optionally gives some text for each variable to be written
+	// in a comment, in formats that support comments.
+	Comments map[string]string
+
+	// WrapStrings, if greater than zero, is the length above which string
+	// values are written as heredocs wrapped to that length, in formats
+	// that support heredocs.
+	WrapStrings int
+
+	// Sections, if non-nil, divides the variables into groups that are
+	// each written under a heading comment, in formats that support
+	// comments. Names then gives only the order of the variables within
+	// each section.
+	Sections []outputSection
+}
+
+// outputSection is a group of variables to be written together under a
+// heading, for --group-by-source.
+type outputSection struct {
+	Heading string
+	Names   []string
+}
+
+// buildOutputFile produces a new file containing the given attributes in
+// tfvars format.
+func buildOutputFile(content *outputContent) *hclwrite.File {
+	if content.Sections != nil {
+		return buildSectionedOutputFile(content)
+	}
+
+	outF := hclwrite.NewEmptyFile()
+	outBody := outF.Body()
+	for _, name := range content.Names {
+		attr, ok := content.Attrs[name]
+		if !ok {
+			continue
+		}
+
+		// We're not going to do any further wrangling of the attributes, so
+		// for simplicity we'll just paste them in as unstructured tokens
+		// to our output file. That avoids book-keeping around detaching and
+		// re-attaching, because the sequence of tokens will be reconstructed
+		// here.
+		outBody.AppendUnstructuredTokens(attributeOutputTokens(content, name, attr))
+	}
+	return outF
+}
+
+// buildSectionedOutputFile is the variant of buildOutputFile for content
+// that is divided into sections, with each non-empty section separated from
+// the previous one by a blank line.
+func buildSectionedOutputFile(content *outputContent) *hclwrite.File {
+	outF := hclwrite.NewEmptyFile()
+	outBody := outF.Body()
+	for _, section := range content.Sections {
+		var toks hclwrite.Tokens
+		for _, name := range section.Names {
+			if attr, ok := content.Attrs[name]; ok {
+				toks = append(toks, attributeOutputTokens(content, name, attr)...)
+			}
+		}
+		if len(toks) == 0 {
+			continue
+		}
+
+		if len(outBody.BuildTokens(nil)) > 0 {
+			outBody.AppendNewline()
+		}
+		outBody.AppendUnstructuredTokens(hclwrite.Tokens{
+			{
+				Type:  hclsyntax.TokenComment,
+				Bytes: []byte("# " + section.Heading + "\n"),
+			},
+		})
+		outBody.AppendUnstructuredTokens(toks)
+	}
+	return outF
+}
+
+// sourceSections divides the given variable names into one section for
+// each of the given sources, in the same order, according to which source
+// the winning definition of each variable came from. The names keep their
+// given order within each section.
+func sourceSections(names []string, values map[string]*hclsyntax.Attribute, sources []*filtervars.Source) []outputSection {
+	byFilename := make(map[string][]string)
+	for _, name := range names {
+		if attr, ok := values[name]; ok {
+			filename := attr.SrcRange.Filename
+			byFilename[filename] = append(byFilename[filename], name)
+		}
+	}
+
+	sections := make([]outputSection, 0, len(sources))
+	for _, src := range sources {
+		if src == nil {
+			continue
+		}
+		sections = append(sections, outputSection{
+			Heading: "From " + src.Name,
+			Names:   byFilename[src.Name],
+		})
+		// A source that appears more than once, such as the same file
+		// given twice, gets all of its variables in its first section.
+		delete(byFilename, src.Name)
+	}
+	return sections
+}
+
+// attributeOutputTokens returns the tokens representing the given attribute
+// in tfvars format, with any comment and string wrapping that the content
+// calls for.
+func attributeOutputTokens(content *outputContent, name string, attr *hclwrite.Attribute) hclwrite.Tokens {
+	toks := attr.BuildTokens(nil)
+	if comment, ok := content.Comments[name]; ok {
+		toks = appendTrailingComment(toks, comment)
+	}
+	if content.WrapStrings > 0 {
+		toks = wrapStringTokens(toks, attr, content.WrapStrings)
+	}
+	return toks
+}
+
+// appendUndeclaredOutputDiags returns an error for each attribute in the
+// given content that isn't for a variable declared in the module. Filtering
+// should already have excluded them, so this is a last line of defense for
+// the promise that the output never includes undeclared variables.
+func appendUndeclaredOutputDiags(diags []tfconfig.Diagnostic, content *outputContent) []tfconfig.Diagnostic {
+	for _, name := range content.Names {
+		if _, ok := content.Attrs[name]; !ok {
+			continue
+		}
+		if _, declared := content.Module.Variables[name]; !declared {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Undeclared variable in output",
+				Detail:   fmt.Sprintf("The output would include a value for %q, which is not declared in the module. This is a bug in terraform-filter-vars.", name),
+			})
+		}
+	}
+	return diags
+}
+
+// appendOutputSyntaxDiags returns an error for each of the attributes in the
+// given content whose tfvars representation isn't valid HCL, which would
+// indicate a bug in how we reconstruct the attributes. Without this check
+// the result would be a tfvars file that Terraform can't read.
+func appendOutputSyntaxDiags(diags []tfconfig.Diagnostic, content *outputContent) []tfconfig.Diagnostic {
+	for _, name := range content.Names {
+		attr, ok := content.Attrs[name]
+		if !ok {
+			continue
+		}
+		src := attributeOutputTokens(content, name, attr).Bytes()
+		_, hclDiags := hclwrite.ParseConfig(src, name, hcl.Pos{Line: 1, Column: 1})
+		if hclDiags.HasErrors() {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Invalid output for variable",
+				Detail:   fmt.Sprintf("The tfvars definition produced for %q is not valid HCL: %s. This is a bug in terraform-filter-vars.", name, hclDiags.Error()),
+			})
+		}
+	}
+	return diags
+}
+
+// buildMapFile produces a file containing a single object expression with
+// an attribute for each of the given attributes, suitable for embedding
+// elsewhere in a configuration, such as in a locals block.
+//
+// The attribute syntax inside an object constructor is the same as at the
+// top level of a file, so we can reuse the tfvars tokens as-is.
+func buildMapFile(content *outputContent) *hclwrite.File {
+	outF := hclwrite.NewEmptyFile()
+	outBody := outF.Body()
+	outBody.AppendUnstructuredTokens(hclwrite.Tokens{
+		{Type: hclsyntax.TokenOBrace, Bytes: []byte{'{'}},
+		{Type: hclsyntax.TokenNewline, Bytes: []byte{'\n'}},
+	})
+	outBody.AppendUnstructuredTokens(buildOutputFile(content).BuildTokens(nil))
+	outBody.AppendUnstructuredTokens(hclwrite.Tokens{
+		{Type: hclsyntax.TokenCBrace, Bytes: []byte{'}'}},
+		{Type: hclsyntax.TokenNewline, Bytes: []byte{'\n'}},
+	})
+	return outF
+}
+
+// buildLocalsFile produces a new file containing a locals block with a
+// local value for each of the given attributes, for embedding the values in
+// a configuration rather than passing them as root module variables.
+//
+// As with buildMapFile, the attribute syntax in a block body is the same as
+// at the top level of a file, so we can reuse the tfvars tokens as-is.
+func buildLocalsFile(content *outputContent) *hclwrite.File {
+	outF := hclwrite.NewEmptyFile()
+	outBody := outF.Body()
+	outBody.AppendUnstructuredTokens(hclwrite.Tokens{
+		{Type: hclsyntax.TokenIdent, Bytes: []byte("locals")},
+		{Type: hclsyntax.TokenOBrace, Bytes: []byte{'{'}, SpacesBefore: 1},
+		{Type: hclsyntax.TokenNewline, Bytes: []byte{'\n'}},
+	})
+	outBody.AppendUnstructuredTokens(buildOutputFile(content).BuildTokens(nil))
+	outBody.AppendUnstructuredTokens(hclwrite.Tokens{
+		{Type: hclsyntax.TokenCBrace, Bytes: []byte{'}'}},
+		{Type: hclsyntax.TokenNewline, Bytes: []byte{'\n'}},
+	})
+	return outF
+}
+
+// sourceComment returns the text of a comment describing where the given
+// attribute was defined, for --annotate-source. fromJSON is set if the
+// attribute's source was converted from JSON syntax.
+func sourceComment(attr *hclsyntax.Attribute, fromJSON bool) string {
+	ret := fmt.Sprintf("from %s:%d", attr.SrcRange.Filename, attr.SrcRange.Start.Line)
+	if fromJSON {
+		// The value was converted from JSON syntax by ParseJSON, so its
+		// layout won't match the original.
+		ret += ", converted from JSON"
+	}
+	return ret
+}
+
+// appendTrailingComment adds a comment at the end of the line of the given
+// attribute tokens. If the attribute already ends with a comment then the
+// new comment goes on a separate line after it instead.
+func appendTrailingComment(toks hclwrite.Tokens, comment string) hclwrite.Tokens {
+	commentTok := &hclwrite.Token{
+		Type:         hclsyntax.TokenComment,
+		Bytes:        []byte("# " + comment + "\n"),
+		SpacesBefore: 1,
+	}
+
+	ret := make(hclwrite.Tokens, 0, len(toks)+1)
+	if n := len(toks); n > 0 && toks[n-1].Type == hclsyntax.TokenNewline {
+		ret = append(ret, toks[:n-1]...)
+		return append(ret, commentTok)
+	}
+	ret = append(ret, toks...)
+	if n := len(toks); n > 0 && toks[n-1].Type == hclsyntax.TokenComment {
+		commentTok.SpacesBefore = 0
+	}
+	return append(ret, commentTok)
+}
+
+// builtinFormats is the output formats that buildOutput implements itself,
+// in the order they're described in the usage information.
+var builtinFormats = []filtervars.Format{
+	filtervars.FormatTFVars,
+	filtervars.FormatHCLMap,
+	filtervars.FormatMarkdown,
+	filtervars.FormatTFC,
+	filtervars.FormatVarArgs,
+	filtervars.FormatLocals,
+}
+
+// outputFormats returns the names of all of the formats accepted by
+// --format for filtering: the built-in ones followed by any custom formats
+// registered with filtervars.RegisterFormat.
+func outputFormats() []filtervars.Format {
+	return append(append([]filtervars.Format(nil), builtinFormats...), filtervars.Formats()...)
+}
+
+// validOutputFormat returns true if the given name is one of the formats
+// returned by outputFormats.
+func validOutputFormat(name string) bool {
+	for _, format := range outputFormats() {
+		if string(format) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// quotedFormats returns each of the given format names quoted, for use in
+// messages.
+func quotedFormats(formats []filtervars.Format) []string {
+	quoted := make([]string, len(formats))
+	for i, format := range formats {
+		quoted[i] = fmt.Sprintf("%q", format)
+	}
+	return quoted
+}
+
+// quotedFormatList returns the given format names quoted and separated by
+// commas, with "or" before the last, for use in messages.
+func quotedFormatList(formats []filtervars.Format) string {
+	quoted := quotedFormats(formats)
+	if len(quoted) < 2 {
+		return strings.Join(quoted, "")
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + ", or " + quoted[len(quoted)-1]
+}
+
+// buildOutput produces the output content for the given attributes in the
+// given format, which must be one of the formats returned by outputFormats.
+//
+// The result is nil if the content can't be represented in the format, in
+// which case the diagnostics explain why.
+func buildOutput(diags []tfconfig.Diagnostic, format string, content *outputContent) (io.WriterTo, []tfconfig.Diagnostic) {
+	diags = appendUndeclaredOutputDiags(diags, content)
+	switch filtervars.Format(format) {
+	case filtervars.FormatTFVars, filtervars.FormatHCLMap, filtervars.FormatLocals:
+		diags = appendOutputSyntaxDiags(diags, content)
+	}
+	for _, diag := range diags {
+		if diag.Severity == tfconfig.DiagError {
+			return nil, diags
+		}
+	}
+
+	switch filtervars.Format(format) {
+	case filtervars.FormatMarkdown:
+		return buildMarkdownTable(content), diags
+	case filtervars.FormatHCLMap:
+		return buildMapFile(content), diags
+	case filtervars.FormatLocals:
+		return buildLocalsFile(content), diags
+	case filtervars.FormatTFC:
+		return buildTFCVariables(content), diags
+	case filtervars.FormatVarArgs:
+		return buildVarArgs(diags, content)
+	case filtervars.FormatTFVars:
+		return buildOutputFile(content), diags
+	}
+
+	serialize, ok := filtervars.LookupFormat(filtervars.Format(format))
+	if !ok {
+		// Should never happen, because main validates the format first.
+		panic(fmt.Sprintf("unsupported output format %q", format))
+	}
+	var buf bytes.Buffer
+	moreDiags := serialize(&buf, content.Module, content.Names, content.Attrs)
+	diags = append(diags, moreDiags...)
+	for _, diag := range moreDiags {
+		if diag.Severity == tfconfig.DiagError {
+			return nil, diags
+		}
+	}
+	return &buf, diags
+}
+
+// buildMarkdownTable produces a Markdown table describing each of the
+// given attributes along with metadata from the corresponding variable
+// declarations. Values of sensitive variables are redacted.
+func buildMarkdownTable(content *outputContent) *bytes.Buffer {
+	var buf bytes.Buffer
+	buf.WriteString("| Name | Type | Description | Value |\n")
+	buf.WriteString("|------|------|-------------|-------|\n")
+	for _, name := range content.Names {
+		attr, ok := content.Attrs[name]
+		if !ok {
+			continue
+		}
+		v := content.Module.Variables[name]
+
+		typeStr := v.Type
+		if typeStr == "" {
+			typeStr = "any"
+		}
+		valStr := "(sensitive)"
+		if !v.Sensitive {
+			valStr = markdownCode(strings.TrimSpace(string(attr.Expr().BuildTokens(nil).Bytes())))
+		}
+
+		fmt.Fprintf(
+			&buf, "| %s | %s | %s | %s |\n",
+			markdownCell(markdownCode(name)), markdownCell(markdownCode(typeStr)), markdownCell(v.Description), markdownCell(valStr),
+		)
+	}
+	return &buf
+}
+
+// markdownCode returns the given string as a Markdown code span. The span is
+// delimited by a run of backticks longer than any in the string, so that
+// backticks in the string don't end it early.
+func markdownCode(s string) string {
+	longest, run := 0, 0
+	for _, c := range s {
+		if c != '`' {
+			run = 0
+			continue
+		}
+		run++
+		if run > longest {
+			longest = run
+		}
+	}
+	fence := strings.Repeat("`", longest+1)
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		// A backtick next to the fence would be taken as part of it, so
+		// we separate them with a space, which Markdown then removes.
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
+// markdownCell escapes the given string for use in a Markdown table cell,
+// which can contain neither pipes nor newlines.
+func markdownCell(s string) string {
+	s = strings.Replace(s, "|", "\\|", -1)
+	s = strings.Replace(s, "\r\n", "<br>", -1)
+	return strings.Replace(s, "\n", "<br>", -1)
+}
+
+// tfcVariable is the JSON representation of a variable in the Terraform
+// Cloud API.
+type tfcVariable struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+	Category    string `json:"category"`
+	HCL         bool   `json:"hcl"`
+	Sensitive   bool   `json:"sensitive"`
+}
+
+// attributeValue evaluates the expression of the given attribute, which must
+// be a literal value.
+//
+// The expression tokens of a heredoc end with its closing marker, but the
+// parser expects a newline after that, so we add one before parsing.
+func attributeValue(attr *hclwrite.Attribute) (cty.Value, hcl.Diagnostics) {
+	exprSrc := append(attr.Expr().BuildTokens(nil).Bytes(), '\n')
+	expr, hclDiags := hclsyntax.ParseExpression(exprSrc, "", hcl.Pos{Line: 1, Column: 1})
+	if hclDiags.HasErrors() {
+		return cty.DynamicVal, hclDiags
+	}
+	return expr.Value(nil)
+}
+
+// buildTFCVariables produces a JSON array describing each of the given
+// attributes as a Terraform Cloud variable. String values are given
+// literally, while values of other types are given in HCL syntax.
+func buildTFCVariables(content *outputContent) *bytes.Buffer {
+	vars := make([]tfcVariable, 0, len(content.Names))
+	for _, name := range content.Names {
+		attr, ok := content.Attrs[name]
+		if !ok {
+			continue
+		}
+		v := content.Module.Variables[name]
+
+		tfcVar := tfcVariable{
+			Key:         name,
+			Description: v.Description,
+			Category:    "terraform",
+			Sensitive:   v.Sensitive,
+		}
+		if val, hclDiags := attributeValue(attr); !hclDiags.HasErrors() && val.Type() == cty.String && !val.IsNull() {
+			tfcVar.Value = val.AsString()
+			vars = append(vars, tfcVar)
+			continue
+		}
+		tfcVar.Value = strings.TrimSpace(string(attr.Expr().BuildTokens(nil).Bytes()))
+		tfcVar.HCL = true
+		vars = append(vars, tfcVar)
+	}
+
+	src, err := json.MarshalIndent(vars, "", "  ")
+	if err != nil {
+		// Should never happen, because we're only encoding strings and bools.
+		panic(err)
+	}
+	buf := bytes.NewBuffer(src)
+	buf.WriteByte('\n')
+	return buf
+}
+
+// buildPositionsJSON produces a JSON object describing the positions of the
+// definitions of each variable, for --positions-json.
+func buildPositionsJSON(defs map[string][]filtervars.Position) *bytes.Buffer {
+	// encoding/json writes map keys in lexical order, so the result is
+	// deterministic.
+	src, err := json.MarshalIndent(defs, "", "  ")
+	if err != nil {
+		// Should never happen, because we're only encoding strings and ints.
+		panic(err)
+	}
+	buf := bytes.NewBuffer(src)
+	buf.WriteByte('\n')
+	return buf
+}
+
+// provenanceVariable is the JSON structure written by --provenance for each
+// variable, describing where its value came from.
+type provenanceVariable struct {
+	Source string `json:"source"`
+	Line   int    `json:"line"`
+
+	// AlsoDefinedIn lists the other sources that defined the variable, whose
+	// values were overridden, in the order they were read.
+	AlsoDefinedIn []string `json:"also_defined_in"`
+}
+
+// buildProvenance produces the sidecar JSON file for --provenance, describing
+// the winning definition of each of the given variables along with the other
+// sources that also defined it. Filenames are made absolute, except for
+// sources that aren't local files.
+func buildProvenance(defs map[string][]filtervars.Position, names []string) *bytes.Buffer {
+	ret := make(map[string]provenanceVariable, len(names))
+	for _, name := range names {
+		positions := defs[name]
+		if len(positions) == 0 {
+			continue
+		}
+		winner := positions[len(positions)-1]
+		v := provenanceVariable{
+			Source:        provenanceFilename(winner.Filename),
+			Line:          winner.Line,
+			AlsoDefinedIn: make([]string, 0, len(positions)-1),
+		}
+		for _, pos := range positions[:len(positions)-1] {
+			v.AlsoDefinedIn = append(v.AlsoDefinedIn, provenanceFilename(pos.Filename))
+		}
+		ret[name] = v
+	}
+
+	src, err := json.MarshalIndent(ret, "", "  ")
+	if err != nil {
+		// Should never happen, because we're only encoding strings and ints.
+		panic(err)
+	}
+	buf := bytes.NewBuffer(src)
+	buf.WriteByte('\n')
+	return buf
+}
+
+// provenanceFilename returns the absolute form of the given source filename,
+// or the filename unchanged if it isn't a local file.
+func provenanceFilename(filename string) string {
+	if strings.HasPrefix(filename, "<") || remoteVarFile(filename) {
+		return filename
+	}
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filename
+}
+
+// buildVarArgs produces a -var option for each of the given attributes, one
+// per line and quoted for a POSIX shell, so that the result can be used in a
+// shell command line or passed to xargs.
+//
+// Terraform takes a -var value literally if its variable has a primitive
+// type constraint or no type constraint at all, and otherwise parses it as
+// an HCL expression, so each value is written in the form its variable
+// expects. Expressions are written in JSON-compatible syntax so that they
+// fit on one line.
+func buildVarArgs(diags []tfconfig.Diagnostic, content *outputContent) (io.WriterTo, []tfconfig.Diagnostic) {
+	var buf bytes.Buffer
+	for _, name := range content.Names {
+		attr, ok := content.Attrs[name]
+		if !ok {
+			continue
+		}
+		v := content.Module.Variables[name]
+
+		val, hclDiags := attributeValue(attr)
+		if hclDiags.HasErrors() {
+			diags = appendHCLDiags(diags, hclDiags)
+			continue
+		}
+
+		literal := true
+		if ty, err := variableTypeConstraint(v); err == nil && ty != cty.DynamicPseudoType && !ty.IsPrimitiveType() {
+			literal = false
+		}
+
+		var valStr string
+		switch {
+		case literal && val.IsNull():
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Value can't be a -var option",
+				Detail:   fmt.Sprintf("The value for %q is null, but Terraform takes -var values for variables of primitive type as literal strings, so there's no way to give null.", name),
+			})
+			continue
+		case literal && val.Type().IsPrimitiveType():
+			valStr = literalVarValue(val)
+		case literal:
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Value can't be a -var option",
+				Detail:   fmt.Sprintf("The value for %q is a collection, but Terraform takes -var values literally for variables without a collection type constraint.", name),
+			})
+			continue
+		default:
+			src, err := ctyjson.Marshal(val, val.Type())
+			if err != nil {
+				diags = append(diags, tfconfig.Diagnostic{
+					Severity: tfconfig.DiagError,
+					Summary:  "Value can't be a -var option",
+					Detail:   fmt.Sprintf("The value for %q can't be written as an expression: %s.", name, err),
+				})
+				continue
+			}
+			// JSON strings are also valid HCL strings except that HCL
+			// would interpret any template sequences in them.
+			valStr = strings.NewReplacer("${", "$${", "%{", "%%{").Replace(string(src))
+		}
+
+		fmt.Fprintf(&buf, "-var %s\n", shellQuote(name+"="+valStr))
+	}
+	for _, diag := range diags {
+		if diag.Severity == tfconfig.DiagError {
+			return nil, diags
+		}
+	}
+	return &buf, diags
+}
+
+// literalVarValue returns the given primitive value as Terraform would
+// expect to find it in a literal -var value.
+func literalVarValue(val cty.Value) string {
+	switch val.Type() {
+	case cty.String:
+		return val.AsString()
+	case cty.Bool:
+		return fmt.Sprintf("%t", val.True())
+	default:
+		return val.AsBigFloat().Text('f', -1)
+	}
+}
+
+// schemaVariable is the JSON representation of a variable declaration
+// written by --schema-out.
+type schemaVariable struct {
+	Type        string      `json:"type"`
+	Default     interface{} `json:"default"`
+	Description string      `json:"description"`
+	Sensitive   bool        `json:"sensitive"`
+	Required    bool        `json:"required"`
+}
+
+// buildVariableSchema produces a JSON object describing each of the
+// variables declared in the given module, for --schema-out. A variable
+// without a type constraint has the type "any".
+func buildVariableSchema(mod *tfconfig.Module) *bytes.Buffer {
+	schema := make(map[string]schemaVariable, len(mod.Variables))
+	for name, v := range mod.Variables {
+		typeStr := v.Type
+		if typeStr == "" {
+			typeStr = "any"
+		}
+		schema[name] = schemaVariable{
+			Type:        typeStr,
+			Default:     v.Default,
+			Description: v.Description,
+			Sensitive:   v.Sensitive,
+			Required:    v.Required,
+		}
+	}
+
+	// encoding/json writes map keys in lexical order, so the result is
+	// deterministic.
+	src, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		// Should never happen, because tfconfig decodes defaults from JSON.
+		panic(err)
+	}
+	buf := bytes.NewBuffer(src)
+	buf.WriteByte('\n')
+	return buf
+}
+
+// showDryRun reports to stderr what buildOutputFile and writeOutputFile
+// would produce for the same arguments, without actually writing anything.
+func showDryRun(path string, content *outputContent) {
+	var included []string
+	for _, name := range content.Names {
+		if _, ok := content.Attrs[name]; ok {
+			included = append(included, name)
+		}
+	}
+
+	dest := path
+	if dest == "-" {
+		dest = "stdout"
+	}
+	fmt.Fprintf(os.Stderr, "Would write %d variables to %s", len(included), dest)
+	if len(included) > 0 {
+		fmt.Fprintf(os.Stderr, ": %s", strings.Join(included, ", "))
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// outputOptions are the settings that affect how writeOutputFile
+// serializes its result.
+type outputOptions struct {
+	// Compress causes the output to be gzip-compressed. Compression is
+	// also implied by an output filename with a .gz suffix.
+	Compress bool
+
+	// Format causes HCL output to be rewritten in the canonical style,
+	// using Indent spaces per level of nesting, or tabs if UseTabs is set.
+	Format  bool
+	Indent  int
+	UseTabs bool
+
+	// EOL, if set, is the line ending to use throughout the output: either
+	// "lf" or "crlf". Otherwise line endings are written as they appeared
+	// in the input.
+	EOL string
+
+	// TrimSpace causes whitespace at the ends of lines in HCL output to
+	// be removed, except where it's part of a value.
+	TrimSpace bool
+
+	// ValidateCmd, if set, is a shell command that must accept the output
+	// on its stdin before it's written.
+	ValidateCmd string
+}
+
+// convertLineEndings returns the content written by the given WriterTo with
+// all of its line endings rewritten to the given style, as for
+// outputOptions.EOL.
+func convertLineEndings(outF io.WriterTo, eol string) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	if _, err := outF.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	src := bytes.Replace(buf.Bytes(), []byte("\r\n"), []byte("\n"), -1)
+	if eol == "crlf" {
+		src = bytes.Replace(src, []byte("\n"), []byte("\r\n"), -1)
+	}
+	return bytes.NewBuffer(src), nil
+}
+
+// formatHCL returns the content of the given file in canonical style, with
+// the indentation requested in the given options.
+func formatHCL(f *hclwrite.File, opts outputOptions) *bytes.Buffer {
+	src := hclwrite.Format(f.Bytes())
+	var buf bytes.Buffer
+	if opts.Indent == 2 && !opts.UseTabs {
+		buf.Write(src)
+		return &buf
+	}
+
+	// hclwrite.Format always indents by two spaces per level, so we'll
+	// re-parse the result and rewrite the leading whitespace on each line.
+	// The content of heredoc templates is part of the token bytes rather
+	// than the preceding spaces, so it is preserved as written.
+	formatted, _ := hclwrite.ParseConfig(src, "", hcl.Pos{Line: 1, Column: 1})
+	lineStart := true
+	for _, tok := range formatted.BuildTokens(nil) {
+		if lineStart {
+			level := tok.SpacesBefore / 2
+			if opts.UseTabs {
+				buf.WriteString(strings.Repeat("\t", level))
+			} else {
+				buf.WriteString(strings.Repeat(" ", level*opts.Indent))
+			}
+		} else {
+			buf.WriteString(strings.Repeat(" ", tok.SpacesBefore))
+		}
+		buf.Write(tok.Bytes)
+		lineStart = len(tok.Bytes) > 0 && tok.Bytes[len(tok.Bytes)-1] == '\n'
+	}
+	return &buf
+}
+
+// outputIsStream returns true if the given output path is an existing
+// destination that isn't a regular file, such as a named pipe or the /dev/fd
+// path of a bash process substitution. Such a destination is written
+// directly, so that the reader on the other end gets the stream as-is,
+// rather than being replaced like a regular file.
+func outputIsStream(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.Mode().IsRegular() && !info.IsDir()
+}
+
+// writeOutput writes the given output content to the given writer,
+// gzip-compressing it if compress is set.
+func writeOutput(w io.Writer, outF io.WriterTo, compress bool) error {
+	if !compress {
+		_, err := outF.WriteTo(w)
+		return err
+	}
+	gzipWr := gzip.NewWriter(w)
+	if _, err := outF.WriteTo(gzipWr); err != nil {
+		return err
+	}
+	// Close flushes the remaining compressed data and the gzip footer, but
+	// leaves the underlying writer open.
+	return gzipWr.Close()
+}
+
+// appendOutputPathDiags returns an error if the given output path can't be
+// written, distinguishing the common reasons for that so the message can
+// say what to fix. The given error is the result of trying to open the path,
+// or nil to check only for problems that can be detected in advance.
+func appendOutputPathDiags(diags []tfconfig.Diagnostic, path string, err error) []tfconfig.Diagnostic {
+	dir := filepath.Dir(path)
+	if info, statErr := os.Stat(dir); os.IsNotExist(statErr) {
+		return append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Output directory not found",
+			Detail:   fmt.Sprintf("Can't create %s, because the directory %s does not exist.", path, dir),
+		})
+	} else if statErr == nil && !info.IsDir() {
+		return append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Output directory is not a directory",
+			Detail:   fmt.Sprintf("Can't create %s, because %s is a file rather than a directory.", path, dir),
+		})
+	}
+	if info, statErr := os.Stat(path); statErr == nil && info.IsDir() {
+		return append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Output path is a directory",
+			Detail:   fmt.Sprintf("Can't write to %s, because it is a directory. Give the path of a file to create inside it instead.", path),
+		})
+	}
+
+	switch {
+	case err == nil:
+		return diags
+	case os.IsPermission(err):
+		return append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Permission denied for output file",
+			Detail:   fmt.Sprintf("Can't create %s, because this process doesn't have permission to write it or the directory containing it.", path),
+		})
+	default:
+		return append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Failed to open output file",
+			Detail:   fmt.Sprintf("Can't create %s: %s.", path, err),
+		})
+	}
+}
+
+// trimTrailingSpace returns the given output content with any whitespace at
+// the ends of its lines removed, if it's HCL output.
+//
+// Only the whitespace that is formatting is trimmed: spaces before newlines
+// and at the ends of comment lines. Whitespace at the ends of lines in a
+// heredoc template is part of its value, and so it's kept. Other formats
+// are returned unchanged, because their lines can't be told apart from
+// the lines of multi-line values.
+func trimTrailingSpace(outF io.WriterTo) io.WriterTo {
+	f, ok := outF.(*hclwrite.File)
+	if !ok {
+		return outF
+	}
+
+	// The tokens are shared with the input files, so we'll make trimmed
+	// copies rather than modifying them in-place.
+	toks := f.BuildTokens(nil)
+	trimmed := make(hclwrite.Tokens, len(toks))
+	for i, tok := range toks {
+		newTok := *tok
+		switch tok.Type {
+		case hclsyntax.TokenNewline, hclsyntax.TokenEOF:
+			newTok.SpacesBefore = 0
+		case hclsyntax.TokenComment:
+			var buf strings.Builder
+			for _, line := range strings.SplitAfter(string(tok.Bytes), "\n") {
+				buf.WriteString(trimLineEnd(line))
+			}
+			newTok.Bytes = []byte(buf.String())
+		}
+		trimmed[i] = &newTok
+	}
+	newF := hclwrite.NewEmptyFile()
+	newF.Body().AppendUnstructuredTokens(trimmed)
+	return newF
+}
+
+// trimLineEnd removes any spaces and tabs from the end of the given line,
+// keeping its line ending, if any.
+func trimLineEnd(line string) string {
+	nl := ""
+	switch {
+	case strings.HasSuffix(line, "\r\n"):
+		nl = "\r\n"
+	case strings.HasSuffix(line, "\n"):
+		nl = "\n"
+	}
+	return strings.TrimRight(strings.TrimSuffix(line, nl), " \t") + nl
+}
+
+// prepareOutput applies the formatting and line ending settings from the
+// given options to the given output content.
+func prepareOutput(outF io.WriterTo, opts outputOptions) (io.WriterTo, error) {
+	if opts.TrimSpace {
+		outF = trimTrailingSpace(outF)
+	}
+	if f, ok := outF.(*hclwrite.File); ok && opts.Format {
+		outF = formatHCL(f, opts)
+	}
+	if opts.EOL != "" {
+		// Our output is always small enough to convert in memory, and
+		// format-specific writers don't need to know about it that way.
+		converted, err := convertLineEndings(outF, opts.EOL)
+		if err != nil {
+			return nil, err
+		}
+		outF = converted
+	}
+	return outF, nil
+}
+
+// writeOutputFile writes the given file to the given path, or to stdout if
+// the path is "-". If the write fails, it exits with an error.
+func writeOutputFile(diags []tfconfig.Diagnostic, path string, outF io.WriterTo, opts outputOptions) []tfconfig.Diagnostic {
+	if path != "-" {
+		diags = appendOutputPathDiags(diags, path, nil)
+		exitIfErrors(diags)
+	}
+
+	outF, err := prepareOutput(outF, opts)
+	if err != nil {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Failed to write to output file",
+			Detail:   fmt.Sprintf("Error writing to %s: %s.", path, err),
+		})
+		exitWithDiags(diags)
+	}
+	if opts.ValidateCmd != "" {
+		var buf bytes.Buffer
+		if _, err := outF.WriteTo(&buf); err != nil {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Failed to write to output file",
+				Detail:   fmt.Sprintf("Error writing to %s: %s.", path, err),
+			})
+			exitWithDiags(diags)
+		}
+		diags = appendValidateDiags(diags, opts.ValidateCmd, path, buf.Bytes())
+		exitIfErrors(diags)
+		outF = &buf
+	}
+
+	compress := opts.Compress || strings.HasSuffix(path, ".gz")
+	switch {
+	case path == "-":
+		err = writeOutput(os.Stdout, outF, compress)
+	case outputIsStream(path):
+		var outWr *os.File
+		outWr, err = os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			diags = appendOutputPathDiags(diags, path, err)
+			exitWithDiags(diags)
+		}
+		err = writeOutput(outWr, outF, compress)
+		if closeErr := outWr.Close(); err == nil {
+			err = closeErr
+		}
+	default:
+		// A regular file is replaced atomically, so that a reader never
+		// sees a partial result and a failed write leaves any existing
+		// file unchanged.
+		var buf bytes.Buffer
+		if err = writeOutput(&buf, outF, compress); err == nil {
+			if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+				diags = appendOutputPathDiags(diags, path, err)
+				exitWithDiags(diags)
+			}
+		}
+	}
+	if err != nil {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Failed to write to output file",
+			Detail:   fmt.Sprintf("Error writing to %s: %s.", path, err),
+		})
+		exitWithDiags(diags)
+	}
+
+	return diags
+}
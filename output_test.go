@@ -0,0 +1,333 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+
+	"github.com/apparentlymart/terraform-filter-vars/internal/filtertest"
+)
+
+func TestCLIOutput(t *testing.T) {
+	// The names are in lexical order within and across these files, and
+	// each is defined only once, so the output order matches the input
+	// order and passing them through changes only their formatting.
+	passthroughA := `# A comment attached to the first value.
+a_string = "hello, $${world}" # trailing comment
+b_number = 1.50
+c_list = [
+  "one",
+  "two", # two
+]
+`
+	passthroughB := `d_map = {
+  key = "value"
+  "quoted key" = true
+}
+e_heredoc = <<EOT
+  keep this indentation
+  and 100% of this text
+EOT
+f_null = null
+g_expr = ["a", "b"][0]
+`
+	passthroughFiles := map[string]string{
+		"mod/variables.tf": `variable "a_string" {}
+variable "b_number" {}
+variable "c_list" {}
+variable "d_map" {}
+variable "e_heredoc" {}
+variable "f_null" {}
+variable "g_expr" {}
+`,
+		"a.tfvars": passthroughA,
+		"b.tfvars": passthroughB,
+	}
+
+	// The escapes for literal template sequences must pass through to HCL
+	// output unchanged, while conversions to other formats use the values
+	// that they stand for.
+	escapes := `directive = "%%{if}"
+heredoc   = <<EOT
+keep $${x} and %%{if} literal
+EOT
+list      = ["$${x}", "%%{if}"]
+percent   = "100%"
+template  = "$${x}"
+`
+	escapeFiles := map[string]string{
+		"mod/variables.tf": `variable "directive" {
+  type = string
+}
+variable "heredoc" {
+  type = string
+}
+variable "list" {
+  type = list(string)
+}
+variable "percent" {
+  type = string
+}
+variable "template" {
+  type = string
+}
+`,
+		"in.tfvars": escapes,
+	}
+
+	// Trailing whitespace in comments and after values is formatting, but
+	// in heredoc and quoted strings it's part of the value.
+	trailing := "# comment with trailing spaces   \n" +
+		"heredoc = <<EOT\n" +
+		"line one  \n" +
+		"line two\t\n" +
+		"EOT\n" +
+		"quoted  = \"ends with spaces  \"\n"
+	trailingFiles := map[string]string{
+		"mod/variables.tf": "variable \"heredoc\" {}\nvariable \"quoted\" {}\n",
+		"in.tfvars":        trailing,
+	}
+	trailingVarArgs := "-var 'heredoc=line one  \nline two\t\n'\n" +
+		"-var 'quoted=ends with spaces  '\n"
+
+	runCLITests(t, map[string]cliTest{
+		"passthrough": {
+			Files:      passthroughFiles,
+			Args:       []string{"mod", "a.tfvars", "b.tfvars"},
+			WantStdout: string(hclwrite.Format([]byte(passthroughA + passthroughB))),
+		},
+		"escapes in tfvars": {
+			Files:      escapeFiles,
+			Args:       []string{"mod", "in.tfvars"},
+			WantStdout: escapes,
+		},
+		"escapes in var-args": {
+			Files: escapeFiles,
+			Args:  []string{"--format", "var-args", "mod", "in.tfvars"},
+			WantStdout: `-var 'directive=%{if}'
+-var 'heredoc=keep ${x} and %{if} literal
+'
+-var 'list=["$${x}","%%{if}"]'
+-var 'percent=100%'
+-var 'template=${x}'
+`,
+		},
+		"escapes in wrapped strings": {
+			Files: escapeFiles,
+			Args:  []string{"--wrap-strings", "8", "mod", "in.tfvars"},
+			WantStdout: `directive = "%%{if}"
+heredoc   = <<-EOT
+  keep $${x
+  } and %%{
+  if} lite
+  ral
+  EOT
+list      = ["$${x}", "%%{if}"]
+percent   = "100%"
+template  = "$${x}"
+`,
+		},
+		"trailing whitespace in tfvars": {
+			Files: trailingFiles,
+			Args:  []string{"mod", "in.tfvars"},
+			WantStdout: "# comment with trailing spaces\n" +
+				"heredoc = <<EOT\n" +
+				"line one  \n" +
+				"line two\t\n" +
+				"EOT\n" +
+				"quoted  = \"ends with spaces  \"\n",
+		},
+		"trailing whitespace in tfvars with --no-trim": {
+			Files:      trailingFiles,
+			Args:       []string{"--no-trim", "mod", "in.tfvars"},
+			WantStdout: trailing,
+		},
+		"trailing whitespace in var-args": {
+			Files:      trailingFiles,
+			Args:       []string{"--format", "var-args", "mod", "in.tfvars"},
+			WantStdout: trailingVarArgs,
+		},
+		"trailing whitespace in var-args with --no-trim": {
+			Files:      trailingFiles,
+			Args:       []string{"--no-trim", "--format", "var-args", "mod", "in.tfvars"},
+			WantStdout: trailingVarArgs,
+		},
+	})
+}
+
+func TestCLIMarkdown(t *testing.T) {
+	files := map[string]string{
+		"mod/variables.tf": `variable "cmd" {
+  type        = string
+  description = "Shell command | pipeline"
+}
+variable "fence" {
+  description = "Has a\nsecond line"
+}
+variable "secret" {
+  sensitive = true
+}
+variable "tags" {
+  type = map(string)
+}
+`,
+		"in.tfvars": "cmd = \"echo `date` | tee log\"\nfence = \"a ``` b\"\nsecret = \"s3cret\"\ntags = { a = \"b\" }\n",
+	}
+
+	runCLITests(t, map[string]cliTest{
+		"table": {
+			Files: files,
+			Args:  []string{"--format", "markdown", "mod", "in.tfvars"},
+			WantStdout: "| Name | Type | Description | Value |\n" +
+				"|------|------|-------------|-------|\n" +
+				"| `cmd` | `string` | Shell command \\| pipeline | ``\"echo `date` \\| tee log\"`` |\n" +
+				"| `fence` | `any` | Has a<br>second line | ````\"a ``` b\"```` |\n" +
+				"| `secret` | `any` |  | (sensitive) |\n" +
+				"| `tags` | `map(string)` |  | `{ a = \"b\" }` |\n",
+		},
+		"no values": {
+			Files:      files,
+			Args:       []string{"--format", "markdown", "mod"},
+			WantStdout: "| Name | Type | Description | Value |\n|------|------|-------------|-------|\n",
+		},
+	})
+}
+
+func TestMarkdownCode(t *testing.T) {
+	tests := map[string]string{
+		"plain":             "`plain`",
+		"a `tick`":          "`` a `tick` ``",
+		"two `` ticks":      "```two `` ticks```",
+		"`starts":           "`` `starts ``",
+		"ends`":             "`` ends` ``",
+		"pipe | not fenced": "`pipe | not fenced`",
+	}
+	for input, want := range tests {
+		if got := markdownCode(input); got != want {
+			t.Errorf("wrong result for %q\ngot:  %s\nwant: %s", input, got, want)
+		}
+	}
+}
+
+func TestCLIOutFile(t *testing.T) {
+	files := withTestModule(map[string]string{
+		"a.tfvars": "region = \"us-east-1\"\n",
+	})
+	const want = "region = \"us-east-1\"\n"
+
+	// checkOnly returns a Check function that fails if the working
+	// directory has any files other than the given ones and those written
+	// before the run, such as a leftover temporary file.
+	checkOnly := func(names ...string) func(*testing.T, string) {
+		return func(t *testing.T, dir string) {
+			infos, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := map[string]bool{"a.tfvars": true, "mod": true}
+			for _, name := range names {
+				expected[name] = true
+			}
+			for _, info := range infos {
+				if !expected[info.Name()] {
+					t.Errorf("unexpected file %s", info.Name())
+				}
+			}
+		}
+	}
+
+	runCLITests(t, map[string]cliTest{
+		"new file": {
+			Files:     files,
+			Args:      []string{"-o", "out.tfvars", "mod", "a.tfvars"},
+			WantFiles: map[string]string{"out.tfvars": want},
+			Check:     checkOnly("out.tfvars"),
+		},
+		"existing file": {
+			Files: files,
+			Setup: func(t *testing.T, dir string) {
+				path := filtertest.WriteFile(t, dir, "out.tfvars", "old content that is longer than the new\n")
+				if err := os.Chmod(path, 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			Args:      []string{"-o", "out.tfvars", "mod", "a.tfvars"},
+			WantFiles: map[string]string{"out.tfvars": want},
+			Check: func(t *testing.T, dir string) {
+				checkOnly("out.tfvars")(t, dir)
+				info, err := os.Stat(filepath.Join(dir, "out.tfvars"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := info.Mode().Perm(); got != 0600 {
+					t.Errorf("wrong permissions %o; want 600", got)
+				}
+			},
+		},
+		"symlink": {
+			Files: files,
+			Setup: func(t *testing.T, dir string) {
+				filtertest.WriteFile(t, dir, "target.tfvars", "old\n")
+				if err := os.Symlink("target.tfvars", filepath.Join(dir, "link.tfvars")); err != nil {
+					t.Skipf("can't create symlink: %s", err)
+				}
+			},
+			Args:      []string{"-o", "link.tfvars", "mod", "a.tfvars"},
+			WantFiles: map[string]string{"target.tfvars": want},
+			Check: func(t *testing.T, dir string) {
+				checkOnly("target.tfvars", "link.tfvars")(t, dir)
+				if info, err := os.Lstat(filepath.Join(dir, "link.tfvars")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+					t.Errorf("link.tfvars is no longer a symlink")
+				}
+			},
+		},
+		"compressed": {
+			Files: files,
+			Args:  []string{"-o", "out.tfvars.gz", "mod", "a.tfvars"},
+			Check: func(t *testing.T, dir string) {
+				checkOnly("out.tfvars.gz")(t, dir)
+				f, err := os.Open(filepath.Join(dir, "out.tfvars.gz"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				r, err := gzip.NewReader(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("wrong content\ngot:\n%s\nwant:\n%s", got, want)
+				}
+			},
+		},
+		"stream": {
+			Files:      files,
+			Args:       []string{"-o", "/dev/stdout", "mod", "a.tfvars"},
+			WantStdout: want,
+		},
+		"missing directory": {
+			Files:      files,
+			Args:       []string{"-o", "missing/out.tfvars", "mod", "a.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"Error: Output directory not found; Can't create missing/out.tfvars, because the directory missing does not exist."},
+		},
+		"rejected by validation": {
+			Files: files,
+			Setup: func(t *testing.T, dir string) {
+				filtertest.WriteFile(t, dir, "out.tfvars", "old\n")
+			},
+			Args:      []string{"--validate-cmd", "false", "-o", "out.tfvars", "mod", "a.tfvars"},
+			WantExit:  1,
+			WantFiles: map[string]string{"out.tfvars": "old\n"},
+			Check:     checkOnly("out.tfvars"),
+		},
+	})
+}
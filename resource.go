@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+)
+
+// resourceVariables returns the names of the variables that the
+// configuration block with the given address refers to directly, for
+// --for-resource. The address is like aws_instance.example for a managed
+// resource, data.aws_ami.example for a data resource, or module.example for
+// a module call.
+//
+// Only native syntax .tf files are searched, and references made indirectly
+// through local values aren't followed.
+func resourceVariables(diags []tfconfig.Diagnostic, dir string, addr string) (map[string]bool, []tfconfig.Diagnostic) {
+	blockType, labels, ok := parseResourceAddr(addr)
+	if !ok {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Invalid resource address",
+			Detail:   fmt.Sprintf("The address %q must be like aws_instance.example, data.aws_ami.example, or module.example.", addr),
+		})
+		return nil, diags
+	}
+	if dir == "" {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "No module directory",
+			Detail:   "The --for-resource option needs the module's configuration files, so it can't be used with --module-json, --template-vars, or --declared-from-files.",
+		})
+		return nil, diags
+	}
+
+	filenames, _ := filepath.Glob(filepath.Join(dir, "*.tf"))
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		// tfconfig has already reported any errors in these files, so we
+		// just use whatever the parser was able to recover.
+		f, _ := hclsyntax.ParseConfig(src, filename, hcl.Pos{Line: 1, Column: 1})
+		body, ok := f.Body.(*hclsyntax.Body)
+		if !ok {
+			continue
+		}
+		for _, block := range body.Blocks {
+			if block.Type != blockType || !sameLabels(block.Labels, labels) {
+				continue
+			}
+			ret := make(map[string]bool)
+			collectBodyVariables(block.Body, ret)
+			return ret, diags
+		}
+	}
+
+	diags = append(diags, tfconfig.Diagnostic{
+		Severity: tfconfig.DiagError,
+		Summary:  "Resource not found",
+		Detail:   fmt.Sprintf("The module in %s doesn't contain %s.", dir, addr),
+	})
+	return nil, diags
+}
+
+// parseResourceAddr returns the block type and labels of the configuration
+// block with the given address, as described for resourceVariables.
+func parseResourceAddr(addr string) (blockType string, labels []string, ok bool) {
+	parts := strings.Split(addr, ".")
+	switch {
+	case len(parts) == 2 && parts[0] == "module":
+		return "module", parts[1:], true
+	case len(parts) == 3 && parts[0] == "data":
+		return "data", parts[1:], true
+	case len(parts) == 2 && parts[0] != "data":
+		return "resource", parts, true
+	default:
+		return "", nil, false
+	}
+}
+
+// sameLabels returns true if the two given lists of block labels are equal.
+func sameLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// collectBodyVariables adds to the given set the name of each variable that
+// the expressions in the given body refer to, including in nested blocks.
+func collectBodyVariables(body *hclsyntax.Body, names map[string]bool) {
+	for _, attr := range body.Attributes {
+		for _, traversal := range attr.Expr.Variables() {
+			if traversal.RootName() != "var" || len(traversal) < 2 {
+				continue
+			}
+			if step, ok := traversal[1].(hcl.TraverseAttr); ok {
+				names[step.Name] = true
+			}
+		}
+	}
+	for _, block := range body.Blocks {
+		collectBodyVariables(block.Body, names)
+	}
+}
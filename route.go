@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+)
+
+// outputRoute directs the variables whose names match a glob pattern to a
+// particular output file, for --route.
+type outputRoute struct {
+	Pattern string
+	Path    string
+}
+
+// parseRoutes parses the given --route arguments, each of which is a
+// pattern and a path separated by an equals sign.
+func parseRoutes(diags []tfconfig.Diagnostic, specs []string) ([]outputRoute, []tfconfig.Diagnostic) {
+	routes := make([]outputRoute, 0, len(specs))
+	for _, spec := range specs {
+		eq := strings.Index(spec, "=")
+		if eq < 1 || eq == len(spec)-1 {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Invalid output route",
+				Detail:   fmt.Sprintf("The route %q must be a variable name pattern and an output path separated by an equals sign, like 'network_*=network.tfvars'.", spec),
+			})
+			continue
+		}
+		route := outputRoute{
+			Pattern: spec[:eq],
+			Path:    spec[eq+1:],
+		}
+		if _, err := path.Match(route.Pattern, ""); err != nil {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Invalid output route",
+				Detail:   fmt.Sprintf("The route %q has an invalid pattern %q: %s.", spec, route.Pattern, err),
+			})
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes, diags
+}
+
+// routeNames assigns each of the given variable names to the path of the
+// first route whose pattern matches it, or to the given default path if none
+// do. The result maps each path to its names, preserving their order, along
+// with the paths in the order they should be written.
+func routeNames(routes []outputRoute, defaultPath string, names []string) (map[string][]string, []string) {
+	ret := make(map[string][]string)
+	var paths []string
+	for _, name := range names {
+		dest := defaultPath
+		for _, route := range routes {
+			if matched, _ := path.Match(route.Pattern, name); matched {
+				dest = route.Path
+				break
+			}
+		}
+		if _, exists := ret[dest]; !exists {
+			paths = append(paths, dest)
+		}
+		ret[dest] = append(ret[dest], name)
+	}
+	return ret, paths
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCLIRoute(t *testing.T) {
+	files := withTestModule(map[string]string{
+		"a.tfvars": "region = \"us-east-1\"\nname = \"api\"\ntoken = \"s3cret\"\n",
+	})
+
+	runCLITests(t, map[string]cliTest{
+		"matched and unmatched": {
+			Files: files,
+			Args:  []string{"--route", "reg*=region.tfvars", "-o", "rest.tfvars", "mod", "a.tfvars"},
+			WantFiles: map[string]string{
+				"region.tfvars": "region = \"us-east-1\"\n",
+				"rest.tfvars":   "name  = \"api\"\ntoken = \"s3cret\"\n",
+			},
+		},
+		"unmatched to stdout": {
+			Files:      files,
+			Args:       []string{"--route", "token=secrets.tfvars", "mod", "a.tfvars"},
+			WantStdout: "name   = \"api\"\nregion = \"us-east-1\"\n",
+			WantFiles: map[string]string{
+				"secrets.tfvars": "token = \"s3cret\"\n",
+			},
+		},
+		"first matching route wins": {
+			Files: files,
+			Args:  []string{"--route", "na*=first.tfvars", "--route", "*=second.tfvars", "mod", "a.tfvars"},
+			WantFiles: map[string]string{
+				"first.tfvars":  "name = \"api\"\n",
+				"second.tfvars": "region = \"us-east-1\"\ntoken  = \"s3cret\"\n",
+			},
+		},
+		"no file without values": {
+			Files:      files,
+			Args:       []string{"--route", "nothing_*=empty.tfvars", "mod", "a.tfvars"},
+			WantStdout: "name   = \"api\"\nregion = \"us-east-1\"\ntoken  = \"s3cret\"\n",
+			Check: func(t *testing.T, dir string) {
+				if _, err := os.Stat(filepath.Join(dir, "empty.tfvars")); !os.IsNotExist(err) {
+					t.Errorf("empty.tfvars was created")
+				}
+			},
+		},
+		"missing path": {
+			Files:      files,
+			Args:       []string{"--route", "name", "mod", "a.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"Error: Invalid output route; The route \"name\" must be a variable name pattern and an output path separated by an equals sign"},
+		},
+		"invalid pattern": {
+			Files:      files,
+			Args:       []string{"--route", "[=x.tfvars", "mod", "a.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"Error: Invalid output route; The route \"[=x.tfvars\" has an invalid pattern \"[\": syntax error in pattern."},
+		},
+		"with split by file": {
+			Files:      files,
+			Args:       []string{"--route", "name=x.tfvars", "--split-by-file", "--out-dir", "out", "mod", "a.tfvars"},
+			WantExit:   1,
+			WantStderr: []string{"Error: Conflicting output options; The --route option can't be used with --split-by-file or --emit-declarations."},
+		},
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// snapshot is the JSON structure written by --snapshot, recording the
+// effective input values so that a later run can detect drift.
+type snapshot struct {
+	Timestamp   string                        `json:"timestamp"`
+	ModuleHash  string                        `json:"module_hash"`
+	Variables   map[string]snapshotVariable   `json:"variables"`
+	SourceFiles map[string]snapshotSourceFile `json:"source_files"`
+}
+
+// snapshotVariable records the final value of a single variable.
+type snapshotVariable struct {
+	// Value is the value in the JSON encoding Terraform uses, or null if
+	// the variable is sensitive.
+	Value     json.RawMessage `json:"value"`
+	Sensitive bool            `json:"sensitive,omitempty"`
+	Source    string          `json:"source"`
+	Line      int             `json:"line"`
+}
+
+// snapshotSourceFile records the content hash of a file that provided at
+// least one of the values.
+type snapshotSourceFile struct {
+	SHA256 string `json:"sha256"`
+}
+
+// buildSnapshot produces the sidecar JSON file for --snapshot, describing
+// each of the given variables in the result.
+//
+// Only sources that are local files have a recorded hash, because the content
+// of other sources, such as environment variables, can't be read again. If a
+// value can't be evaluated then it's recorded as null.
+func buildSnapshot(mod *tfconfig.Module, result *filtervars.Result, names []string) *bytes.Buffer {
+	snap := snapshot{
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		ModuleHash:  moduleSurfaceHash(mod),
+		Variables:   make(map[string]snapshotVariable, len(names)),
+		SourceFiles: make(map[string]snapshotSourceFile),
+	}
+
+	for _, name := range names {
+		attr, ok := result.Values[name]
+		if !ok {
+			continue
+		}
+		filename := attr.SrcRange.Filename
+
+		v := snapshotVariable{
+			Value:  json.RawMessage("null"),
+			Source: filename,
+			Line:   attr.SrcRange.Start.Line,
+		}
+		if decl := mod.Variables[name]; decl != nil && decl.Sensitive {
+			v.Sensitive = true
+		} else if val, hclDiags := attr.Expr.Value(nil); !hclDiags.HasErrors() {
+			if src, err := ctyjson.Marshal(val, val.Type()); err == nil {
+				v.Value = src
+			}
+		}
+		snap.Variables[name] = v
+
+		if _, done := snap.SourceFiles[filename]; done || strings.HasPrefix(filename, "<") || remoteVarFile(filename) {
+			continue
+		}
+		if src, err := ioutil.ReadFile(filename); err == nil {
+			sum := sha256.Sum256(src)
+			snap.SourceFiles[filename] = snapshotSourceFile{SHA256: hex.EncodeToString(sum[:])}
+		}
+	}
+
+	src, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		// Should never happen, because the values were produced by ctyjson.
+		panic(err)
+	}
+	buf := bytes.NewBuffer(src)
+	buf.WriteByte('\n')
+	return buf
+}
+
+// moduleSurfaceHash returns a hash of the parts of the given module's
+// variable declarations that affect which values are accepted: their names,
+// type constraints, defaults, and sensitivity. Other changes to the module,
+// such as to variable descriptions, don't change the hash.
+func moduleSurfaceHash(mod *tfconfig.Module) string {
+	type surfaceVariable struct {
+		Name      string      `json:"name"`
+		Type      string      `json:"type"`
+		Default   interface{} `json:"default"`
+		Required  bool        `json:"required"`
+		Sensitive bool        `json:"sensitive"`
+	}
+
+	names := make([]string, 0, len(mod.Variables))
+	for name := range mod.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	surface := make([]surfaceVariable, len(names))
+	for i, name := range names {
+		v := mod.Variables[name]
+		surface[i] = surfaceVariable{
+			Name:      name,
+			Type:      v.Type,
+			Default:   v.Default,
+			Required:  v.Required,
+			Sensitive: v.Sensitive,
+		}
+	}
+
+	src, err := json.Marshal(surface)
+	if err != nil {
+		// Should never happen, because tfconfig decodes defaults from JSON.
+		panic(err)
+	}
+	sum := sha256.Sum256(src)
+	return hex.EncodeToString(sum[:])
+}
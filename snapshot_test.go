@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCLISnapshot(t *testing.T) {
+	files := withTestModule(map[string]string{
+		"a.tfvars": "region = \"us-east-1\"\nname = \"api\"\ntoken = \"s3cret\"\n",
+		"b.tfvars": "region = \"eu-west-1\"\n",
+	})
+
+	// checkSnapshot returns a Check function that compares the variables
+	// and source files recorded in snap.json with the given ones. The
+	// timestamp and module hash vary, so those are only checked for being
+	// present.
+	checkSnapshot := func(wantVars map[string]snapshotVariable, wantFiles []string) func(*testing.T, string) {
+		return func(t *testing.T, dir string) {
+			src, err := ioutil.ReadFile(filepath.Join(dir, "snap.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var snap snapshot
+			if err := json.Unmarshal(src, &snap); err != nil {
+				t.Fatalf("invalid snapshot: %s\n%s", err, src)
+			}
+			if snap.Timestamp == "" || len(snap.ModuleHash) != 64 {
+				t.Errorf("missing timestamp or module hash\n%s", src)
+			}
+			if !reflect.DeepEqual(snap.Variables, wantVars) {
+				t.Errorf("wrong variables\n%s", src)
+			}
+			var gotFiles []string
+			for path, file := range snap.SourceFiles {
+				gotFiles = append(gotFiles, path)
+				if len(file.SHA256) != 64 {
+					t.Errorf("missing hash for %s", path)
+				}
+			}
+			sort.Strings(gotFiles)
+			if !reflect.DeepEqual(gotFiles, wantFiles) {
+				t.Errorf("wrong source files %q; want %q", gotFiles, wantFiles)
+			}
+		}
+	}
+
+	runCLITests(t, map[string]cliTest{
+		"merged values": {
+			Files:      files,
+			Args:       []string{"--snapshot", "snap.json", "mod", "a.tfvars", "b.tfvars"},
+			WantStdout: "name   = \"api\"\nregion = \"eu-west-1\"\ntoken  = \"s3cret\"\n",
+			Check: checkSnapshot(map[string]snapshotVariable{
+				"name":   {Value: json.RawMessage(`"api"`), Source: "a.tfvars", Line: 2},
+				"region": {Value: json.RawMessage(`"eu-west-1"`), Source: "b.tfvars", Line: 1},
+				"token":  {Value: json.RawMessage(`null`), Sensitive: true, Source: "a.tfvars", Line: 3},
+			}, []string{"a.tfvars", "b.tfvars"}),
+		},
+		"only the written variables": {
+			Files:      files,
+			Args:       []string{"--snapshot", "snap.json", "--only", "required", "mod", "b.tfvars"},
+			WantStdout: "region = \"eu-west-1\"\n",
+			Check: checkSnapshot(map[string]snapshotVariable{
+				"region": {Value: json.RawMessage(`"eu-west-1"`), Source: "b.tfvars", Line: 1},
+			}, []string{"b.tfvars"}),
+		},
+		"no file hash for other sources": {
+			Files:      files,
+			Args:       []string{"--snapshot", "snap.json", "--inline-vars", "region = \"ap-south-1\"", "mod"},
+			WantStdout: "region = \"ap-south-1\"\n",
+			Check: checkSnapshot(map[string]snapshotVariable{
+				"region": {Value: json.RawMessage(`"ap-south-1"`), Source: inlineVarsSourceFilename, Line: 1},
+			}, nil),
+		},
+		"dry run": {
+			Files:      files,
+			Args:       []string{"--snapshot", "snap.json", "--dry-run", "mod", "b.tfvars"},
+			WantStderr: []string{"region"},
+			Check: func(t *testing.T, dir string) {
+				if _, err := ioutil.ReadFile(filepath.Join(dir, "snap.json")); err == nil {
+					t.Error("snapshot was written in dry run mode")
+				}
+			},
+		},
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+)
+
+// applyStableOutput replaces each of the given attributes with the
+// definition of the same variable from the existing output file at the given
+// path, if that definition has the same value. That preserves the exact
+// layout and comments of the existing file for values that haven't changed,
+// so that regenerating the file produces minimal differences.
+//
+// If the file doesn't exist yet then the attributes are left unchanged.
+func applyStableOutput(diags []tfconfig.Diagnostic, path string, attrs map[string]*hclwrite.Attribute, values map[string]*hclsyntax.Attribute) []tfconfig.Diagnostic {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return diags
+	}
+
+	reader := &varFileReader{}
+	existing, moreDiags := reader.Read(path)
+	diags = append(diags, moreDiags...)
+	if existing == nil {
+		return diags
+	}
+
+	existingAttrs := existing.Body.Attributes()
+	for name, syntaxAttr := range values {
+		if _, kept := attrs[name]; !kept {
+			continue
+		}
+		existingSyntaxAttr, ok := existing.SyntaxBody.Attributes[name]
+		if !ok {
+			continue
+		}
+		val, hclDiags := syntaxAttr.Expr.Value(nil)
+		if hclDiags.HasErrors() {
+			continue
+		}
+		existingVal, hclDiags := existingSyntaxAttr.Expr.Value(nil)
+		if hclDiags.HasErrors() {
+			continue
+		}
+		if existingVal.RawEquals(val) {
+			attrs[name] = existingAttrs[name]
+		}
+	}
+	return diags
+}
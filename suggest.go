@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+)
+
+// suggestVariable returns the name of the variable declared in the given
+// module that is most similar to the given undeclared name, for a "did you
+// mean" hint, or an empty string if none is within the given edit distance.
+// A maximum distance of zero or less disables suggestions.
+func suggestVariable(mod *tfconfig.Module, name string, maxDistance int) string {
+	if maxDistance <= 0 {
+		return ""
+	}
+
+	candidates := make([]string, 0, len(mod.Variables))
+	for candidate := range mod.Variables {
+		candidates = append(candidates, candidate)
+	}
+	// Sorting makes the choice between equally-close names deterministic.
+	sort.Strings(candidates)
+
+	best := ""
+	bestDistance := maxDistance + 1
+	for _, candidate := range candidates {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best = candidate
+			bestDistance = d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between the given strings.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// variableTypeConstraint returns the type constraint for the given variable,
+// which is cty.DynamicPseudoType if the variable accepts values of any type.
+func variableTypeConstraint(v *tfconfig.Variable) (cty.Type, error) {
+	switch v.Type {
+	case "":
+		return cty.DynamicPseudoType, nil
+	case "list":
+		// Legacy type keywords from Terraform 0.11 and earlier, which
+		// tfconfig passes through as bare identifiers.
+		return cty.List(cty.DynamicPseudoType), nil
+	case "map":
+		return cty.Map(cty.DynamicPseudoType), nil
+	}
+
+	expr, hclDiags := hclsyntax.ParseExpression([]byte(v.Type), v.Pos.Filename, hcl.Pos{Line: v.Pos.Line, Column: 1})
+	if hclDiags.HasErrors() {
+		return cty.NilType, hclDiags
+	}
+	ty, hclDiags := typeexpr.TypeConstraint(expr)
+	if hclDiags.HasErrors() {
+		return cty.NilType, hclDiags
+	}
+	return ty, nil
+}
+
+// appendTypeCheckDiags checks each of the given values against the type
+// constraint of the corresponding variable in the module, returning errors
+// for any that Terraform would reject.
+func appendTypeCheckDiags(diags []tfconfig.Diagnostic, mod *tfconfig.Module, names []string, values map[string]*hclsyntax.Attribute) []tfconfig.Diagnostic {
+	for _, name := range names {
+		attr, ok := values[name]
+		if !ok {
+			continue
+		}
+		v := mod.Variables[name]
+		pos := &tfconfig.SourcePos{
+			Filename: attr.SrcRange.Filename,
+			Line:     attr.SrcRange.Start.Line,
+		}
+
+		ty, err := variableTypeConstraint(v)
+		if err != nil {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagWarning,
+				Summary:  "Can't check variable type",
+				Detail:   fmt.Sprintf("The type constraint for variable %q is not valid, so its value can't be checked: %s.", name, err),
+				Pos:      &v.Pos,
+			})
+			continue
+		}
+		if ty == cty.DynamicPseudoType {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: diagInfo,
+				Summary:  "Variable type not checked",
+				Detail:   fmt.Sprintf("Variable %q accepts values of any type, so its value was not checked.", name),
+				Pos:      pos,
+			})
+			continue
+		}
+
+		val, hclDiags := attr.Expr.Value(nil)
+		diags = appendHCLDiags(diags, hclDiags)
+		if hclDiags.HasErrors() {
+			continue
+		}
+		if _, err := convert.Convert(val, ty); err != nil {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Invalid value for variable",
+				Detail:   fmt.Sprintf("The value for variable %q is not compatible with its type constraint %s: %s.", name, typeexpr.TypeString(ty), err),
+				Pos:      pos,
+			})
+		}
+	}
+	return diags
+}
+
+// valueMatchesDefault returns true if the given value is equal to the default
+// value of the given variable, after conversion to the variable's type
+// constraint as Terraform would do.
+//
+// tfconfig gives the default only as an approximation using JSON-like Go
+// values, so the comparison is made in that form.
+func valueMatchesDefault(v *tfconfig.Variable, val cty.Value) bool {
+	if v.Required || !val.IsWhollyKnown() {
+		return false
+	}
+	if val.IsNull() {
+		return v.Default == nil
+	}
+	if ty, err := variableTypeConstraint(v); err == nil {
+		if converted, err := convert.Convert(val, ty); err == nil {
+			val = converted
+		}
+	}
+
+	valJSON, err := ctyjson.Marshal(val, val.Type())
+	if err != nil {
+		return false
+	}
+	defaultJSON, err := json.Marshal(v.Default)
+	if err != nil {
+		return false
+	}
+	var got, want interface{}
+	if json.Unmarshal(valJSON, &got) != nil || json.Unmarshal(defaultJSON, &want) != nil {
+		return false
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+// appendDefaultMatchDiags returns a diagnostic of the given severity for each
+// of the given values that just restates the default value of its variable.
+// That's an error for --check-defaults-match, or just a warning for
+// --report-redundant.
+func appendDefaultMatchDiags(diags []tfconfig.Diagnostic, severity tfconfig.DiagSeverity, mod *tfconfig.Module, names []string, values map[string]*hclsyntax.Attribute) []tfconfig.Diagnostic {
+	for _, name := range names {
+		attr, ok := values[name]
+		if !ok {
+			continue
+		}
+		val, hclDiags := attr.Expr.Value(nil)
+		if hclDiags.HasErrors() {
+			continue
+		}
+		if valueMatchesDefault(mod.Variables[name], val) {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: severity,
+				Summary:  "Value matches default",
+				Detail:   fmt.Sprintf("The value for %q is the same as the variable's default value, so it can be removed.", name),
+				Pos: &tfconfig.SourcePos{
+					Filename: attr.SrcRange.Filename,
+					Line:     attr.SrcRange.Start.Line,
+				},
+			})
+		}
+	}
+	return diags
+}
+
+// appendEmptyRequiredDiags returns a warning for each of the given values
+// that is null, an empty string, or an empty collection but is for a
+// required variable, since that often indicates a misconfiguration.
+func appendEmptyRequiredDiags(diags []tfconfig.Diagnostic, mod *tfconfig.Module, names []string, values map[string]*hclsyntax.Attribute) []tfconfig.Diagnostic {
+	for _, name := range names {
+		attr, ok := values[name]
+		if !ok || !mod.Variables[name].Required {
+			continue
+		}
+		val, hclDiags := attr.Expr.Value(nil)
+		if hclDiags.HasErrors() || !valueEmpty(val) {
+			continue
+		}
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagWarning,
+			Summary:  "Empty value for required variable",
+			Detail:   fmt.Sprintf("Variable %q is required, but its value is empty.", name),
+			Pos: &tfconfig.SourcePos{
+				Filename: attr.SrcRange.Filename,
+				Line:     attr.SrcRange.Start.Line,
+			},
+		})
+	}
+	return diags
+}
+
+// valueEmpty returns true if the given value is null, an empty string, or a
+// collection or structural value with no elements.
+func valueEmpty(val cty.Value) bool {
+	switch {
+	case val.IsNull():
+		return true
+	case !val.IsKnown():
+		return false
+	case val.Type() == cty.String:
+		return val.AsString() == ""
+	case val.CanIterateElements():
+		return val.LengthInt() == 0
+	default:
+		return false
+	}
+}
+
+// coerceTransform returns a transform function for filtervars.Filterer that
+// converts each value to the type constraint of its variable in the given
+// module, as Terraform itself would, so that the output has exactly the
+// declared types.
+func coerceTransform(mod *tfconfig.Module) filtervars.TransformFunc {
+	return func(name string, val cty.Value) (cty.Value, *tfconfig.Diagnostic) {
+		v := mod.Variables[name]
+		ty, err := variableTypeConstraint(v)
+		if err != nil || ty == cty.DynamicPseudoType {
+			// appendTypeCheckDiags reports invalid type constraints, if
+			// requested, so we'll just leave the value as-is here.
+			return val, nil
+		}
+		converted, err := convert.Convert(val, ty)
+		if err != nil {
+			return cty.NilVal, &tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Invalid value for variable",
+				Detail:   fmt.Sprintf("The value for variable %q can't be converted to its type constraint %s: %s.", name, typeexpr.TypeString(ty), err),
+			}
+		}
+		return converted, nil
+	}
+}
+
+// dropNullDefinitions removes from the given sources each definition of a
+// declared variable whose value is null, for --drop-nulls, so that the
+// variable takes its value from an earlier source as if the null definition
+// were not there at all. This must happen before the sources are filtered.
+//
+// The result is the last null definition of each variable, so that
+// addDroppedNulls can report those that were left with no value.
+func dropNullDefinitions(mod *tfconfig.Module, sources []*filtervars.Source) map[string]*hclsyntax.Attribute {
+	nulls := make(map[string]*hclsyntax.Attribute)
+	for _, src := range sources {
+		if src == nil {
+			continue
+		}
+		for name, attr := range src.SyntaxBody.Attributes {
+			if _, declared := mod.Variables[name]; !declared {
+				continue
+			}
+			if val, hclDiags := attr.Expr.Value(nil); !hclDiags.HasErrors() && val.IsNull() {
+				nulls[name] = attr
+				delete(src.SyntaxBody.Attributes, name)
+				src.Body.RemoveAttribute(name)
+			}
+		}
+	}
+	return nulls
+}
+
+// addDroppedNulls adds to the given result's dropped values each of the
+// given null definitions, as returned by dropNullDefinitions, whose variable
+// has no value in the result.
+func addDroppedNulls(result *filtervars.Result, nulls map[string]*hclsyntax.Attribute) {
+	names := make([]string, 0, len(nulls))
+	for name := range nulls {
+		if _, ok := result.Values[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result.Dropped = append(result.Dropped, filtervars.NewDroppedValue(nulls[name], "its value is null and --drop-nulls is set"))
+	}
+}
+
+// normalizeNumbers rewrites each number literal in the values in the given
+// result in a canonical form, without trailing zeros or exponents, for
+// --normalize-numbers. For example, 1.0 becomes 1 and 1.5e3 becomes 1500.
+//
+// The definitions are modified in place, so this also affects the sources
+// that produced the result.
+func normalizeNumbers(result *filtervars.Result) {
+	attrs := make([]*hclwrite.Attribute, 0, len(result.Attrs))
+	for _, attr := range result.Attrs {
+		attrs = append(attrs, attr)
+	}
+	for _, srcAttrs := range result.SourceAttrs {
+		for _, attr := range srcAttrs {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	for _, attr := range attrs {
+		for _, tok := range attr.Expr().BuildTokens(nil) {
+			if tok.Type != hclsyntax.TokenNumberLit {
+				continue
+			}
+			val, err := cty.ParseNumberVal(string(tok.Bytes))
+			if err != nil {
+				continue
+			}
+			tok.Bytes = []byte(val.AsBigFloat().Text('f', -1))
+		}
+	}
+}
+
+// appendSensitiveOverrideDiags returns a warning for each definition of a
+// sensitive variable in the given sources that overrides an earlier
+// definition with a different value, for --warn-sensitive-overrides. That
+// often indicates that credentials for one environment have been mixed up
+// with another's.
+//
+// The warnings give only the positions of the two definitions, never the
+// values themselves.
+func appendSensitiveOverrideDiags(diags []tfconfig.Diagnostic, mod *tfconfig.Module, sources []*filtervars.Source) []tfconfig.Diagnostic {
+	type definition struct {
+		attr *hclsyntax.Attribute
+		val  cty.Value
+	}
+	prev := make(map[string]definition)
+
+	for _, src := range sources {
+		if src == nil {
+			continue
+		}
+		for _, name := range src.AttributeNames() {
+			if v := mod.Variables[name]; v == nil || !v.Sensitive {
+				continue
+			}
+			attr := src.SyntaxBody.Attributes[name]
+			val, hclDiags := attr.Expr.Value(nil)
+			if hclDiags.HasErrors() {
+				continue
+			}
+			if earlier, ok := prev[name]; ok && !earlier.val.RawEquals(val) {
+				diags = append(diags, tfconfig.Diagnostic{
+					Severity: tfconfig.DiagWarning,
+					Summary:  "Sensitive value overridden",
+					Detail:   fmt.Sprintf("The sensitive variable %q is defined at %s:%d with a different value than at %s:%d, which it overrides.", name, attr.NameRange.Filename, attr.NameRange.Start.Line, earlier.attr.NameRange.Filename, earlier.attr.NameRange.Start.Line),
+					Pos: &tfconfig.SourcePos{
+						Filename: attr.NameRange.Filename,
+						Line:     attr.NameRange.Start.Line,
+					},
+				})
+			}
+			prev[name] = definition{attr, val}
+		}
+	}
+	return diags
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+)
+
+// appendValidateDiags runs the given shell command with the given output,
+// which is destined for the given path, on its stdin. If the command exits
+// unsuccessfully then the result includes an error whose detail is whatever
+// the command wrote to its stderr.
+func appendValidateDiags(diags []tfconfig.Diagnostic, command string, path string, output []byte) []tfconfig.Diagnostic {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(output)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		dest := path
+		if dest == "-" {
+			dest = "stdout"
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Output rejected by validator",
+			Detail:   fmt.Sprintf("The command %q rejected the output for %s: %s.", command, dest, msg),
+		})
+	}
+	return diags
+}
@@ -0,0 +1,878 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+)
+
+// inlineVarsSourceFilename is the pseudo-filename used in diagnostics about
+// values that were given with --inline-vars.
+const inlineVarsSourceFilename = "<inline>"
+
+// varFileReader reads tfvars files, applying any preprocessing requested
+// on the command line.
+type varFileReader struct {
+	// Data, if non-nil, is used to expand ${data.key} references in the
+	// raw source of each file before parsing.
+	Data map[string]interface{}
+
+	// Strict causes suspicious file content to be reported as an error
+	// rather than as a warning.
+	Strict bool
+
+	// ReadRetries is the number of times to retry reading a file after a
+	// transient error, such as can occur on network filesystems.
+	ReadRetries int
+
+	// SSHIdentity, if set, is the private key file to use when fetching
+	// files from scp:// paths.
+	SSHIdentity string
+
+	// NormalizeLiterals causes values written as differently-capitalized
+	// variants of true, false, or null to be rewritten in lowercase.
+	NormalizeLiterals bool
+
+	// SkipNullInput causes properties of JSON tfvars files whose values are
+	// null to be ignored, as if they were not present at all.
+	SkipNullInput bool
+
+	// FailOnParseWarning causes any warnings from the HCL parser to be
+	// reported as errors instead.
+	FailOnParseWarning bool
+
+	// BestEffort causes a file that can't be read to be reported with a
+	// warning rather than an error, so that the others can still be used.
+	BestEffort bool
+
+	// InlineFiles causes values that call file("path") to be replaced by
+	// the content of the named file, relative to the tfvars file.
+	InlineFiles bool
+
+	// FollowIncludes causes lines like #include other.tfvars in native
+	// syntax files to be followed by reading the named file first, as
+	// described for readIncludes and mergeIncludes.
+	FollowIncludes bool
+
+	// includeStack is the absolute paths of the files whose includes are
+	// being read, as described for readIncludes.
+	includeStack []string
+}
+
+// Read reads and parses the tfvars file at the given path. If the file can't
+// be read or parsed then the returned source is nil and the diagnostics
+// explain why.
+//
+// A file whose name ends in .json is parsed as JSON syntax, using ParseJSON.
+// The syntax can also be chosen explicitly by adding a suffix of :json or
+// :hcl to the path, as described for splitVarFileFormat.
+// A path starting with scp:// is fetched from a remote host using the ssh
+// command, as described for fetchSSHFile, and a path starting with git://
+// is read from a revision in the current Git repository, as described for
+// fetchGitFile.
+//
+// Read doesn't modify the receiver, so it's safe to call concurrently.
+func (r *varFileReader) Read(path string) (*filtervars.Source, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	path, format := splitVarFileFormat(path)
+
+	var src []byte
+	var err error
+	switch {
+	case strings.HasPrefix(path, "scp://"):
+		src, err = fetchSSHFile(path, r.SSHIdentity)
+	case strings.HasPrefix(path, "git://"):
+		var filePath string
+		src, filePath, err = fetchGitFile(path)
+		if format == "" && strings.HasSuffix(filePath, ".json") {
+			format = "json"
+		}
+	default:
+		src, err = readFileWithRetries(path, r.ReadRetries)
+	}
+	if err != nil {
+		severity := tfconfig.DiagError
+		if r.BestEffort {
+			severity = tfconfig.DiagWarning
+		}
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: severity,
+			Summary:  "Failed to read input file",
+			Detail:   fmt.Sprintf("Can't read %s: %s.", path, err),
+		})
+		return nil, diags
+	}
+	vf, moreDiags := r.Load(src, path, format)
+	diags = append(diags, moreDiags...)
+	return vf, diags
+}
+
+// ReadAll reads and parses each of the tfvars files at the given paths, as
+// for Read, using up to the given number of concurrent reads. The results
+// are in the same order as the paths, regardless of the order in which the
+// reads complete.
+func (r *varFileReader) ReadAll(paths []string, parallelism int) ([]*filtervars.Source, [][]tfconfig.Diagnostic) {
+	sources := make([]*filtervars.Source, len(paths))
+	diags := make([][]tfconfig.Diagnostic, len(paths))
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism && w < len(paths); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				sources[i], diags[i] = r.Read(paths[i])
+			}
+		}()
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return sources, diags
+}
+
+// splitVarFileFormat separates an explicit syntax suffix, either :json or
+// :hcl, from the given tfvars file argument. If there's no such suffix then
+// the format is empty, meaning that it should be chosen based on the
+// filename.
+func splitVarFileFormat(arg string) (path, format string) {
+	if colon := strings.LastIndex(arg, ":"); colon > 0 {
+		switch suffix := arg[colon+1:]; suffix {
+		case "json", "hcl":
+			return arg[:colon], suffix
+		}
+	}
+	return arg, ""
+}
+
+// Load preprocesses and parses the given tfvars source code, which was read
+// from the given path, as for Read. The format is either "json" or "hcl" to
+// select a syntax explicitly, or empty to choose based on the filename.
+func (r *varFileReader) Load(src []byte, path string, format string) (*filtervars.Source, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	if r.Data != nil {
+		var expandDiags []tfconfig.Diagnostic
+		src, expandDiags = expandDataRefs(src, path, r.Data)
+		diags = append(diags, expandDiags...)
+		if len(expandDiags) > 0 {
+			return nil, diags
+		}
+	}
+
+	if format == "" {
+		format = "hcl"
+		if strings.HasSuffix(path, ".json") {
+			format = "json"
+		}
+	}
+	parse := r.Parse
+	if format == "json" {
+		parse = r.ParseJSON
+	}
+	vf, moreDiags := parse(src, path)
+	diags = append(diags, moreDiags...)
+	return vf, diags
+}
+
+// Parse parses the given tfvars source code, which was read from the given
+// path. The path is used only for diagnostics, and so needn't be a real
+// file path for content from other sources.
+func (r *varFileReader) Parse(src []byte, path string) (*filtervars.Source, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	var included []*filtervars.Source
+	if r.FollowIncludes {
+		var moreDiags []tfconfig.Diagnostic
+		src, included, moreDiags = r.readIncludes(src, path)
+		diags = append(diags, moreDiags...)
+		if src == nil {
+			return nil, diags
+		}
+	}
+
+	if r.NormalizeLiterals {
+		var moreDiags []tfconfig.Diagnostic
+		src, moreDiags = normalizeLiterals(src, path)
+		diags = append(diags, moreDiags...)
+	}
+
+	if r.InlineFiles {
+		var moreDiags []tfconfig.Diagnostic
+		src, moreDiags = inlineFileCalls(src, path)
+		diags = append(diags, moreDiags...)
+	}
+
+	// The last definition in a file with no final newline would otherwise
+	// run into whatever follows it in the output, so we add one. This is
+	// common for content given with --inline-vars in particular.
+	if len(src) > 0 && src[len(src)-1] != '\n' {
+		src = append(src[:len(src):len(src)], '\n')
+	}
+
+	f, hclDiags := hclwrite.ParseConfig(src, path, hcl.Pos{Line: 1, Column: 1})
+	diags = r.appendParseDiags(diags, hclDiags)
+	if hclDiags.HasErrors() {
+		return nil, diags
+	}
+
+	// hclwrite doesn't retain source positions, so we'll also parse
+	// with hclsyntax in order to give good feedback about the content.
+	// The source already parsed successfully above, so we don't need
+	// to check for diagnostics again here.
+	syntaxFile, _ := hclsyntax.ParseConfig(src, path, hcl.Pos{Line: 1, Column: 1})
+	syntaxBody := syntaxFile.Body.(*hclsyntax.Body)
+	diags = appendTfvarsBlockDiags(diags, syntaxBody, r.Strict)
+
+	vf := &filtervars.Source{
+		Name:       path,
+		Body:       f.Body(),
+		SyntaxBody: syntaxBody,
+	}
+	if len(included) > 0 {
+		var moreDiags []tfconfig.Diagnostic
+		vf, moreDiags = mergeIncludes(vf, included)
+		diags = append(diags, moreDiags...)
+	}
+	return vf, diags
+}
+
+// inlineFileCalls rewrites any attribute values in the given source that
+// call file("path"), which Terraform wouldn't allow in a tfvars file, as
+// literal values where each such call is replaced by the content of the
+// named file. Relative paths are taken relative to the directory containing
+// the given path.
+//
+// If the source can't be parsed then it's returned unchanged, so the
+// caller's own parsing can report the problem.
+func inlineFileCalls(src []byte, path string) ([]byte, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	f, hclDiags := hclsyntax.ParseConfig(src, path, hcl.Pos{Line: 1, Column: 1})
+	if hclDiags.HasErrors() {
+		return src, nil
+	}
+
+	ctx := &hcl.EvalContext{
+		Functions: map[string]function.Function{
+			"file": fileFunction(filepath.Dir(path)),
+		},
+	}
+
+	type replacement struct {
+		rng hcl.Range
+		src []byte
+	}
+	var repls []replacement
+	for _, attr := range f.Body.(*hclsyntax.Body).Attributes {
+		callsFile := false
+		hclsyntax.VisitAll(attr.Expr, func(node hclsyntax.Node) hcl.Diagnostics {
+			if call, ok := node.(*hclsyntax.FunctionCallExpr); ok && call.Name == "file" {
+				callsFile = true
+			}
+			return nil
+		})
+		if !callsFile {
+			continue
+		}
+
+		val, hclDiags := attr.Expr.Value(ctx)
+		diags = appendHCLDiags(diags, hclDiags)
+		if hclDiags.HasErrors() {
+			continue
+		}
+		repls = append(repls, replacement{attr.Expr.Range(), hclwrite.TokensForValue(val).Bytes()})
+	}
+	if len(repls) == 0 {
+		return src, diags
+	}
+	sort.Slice(repls, func(i, j int) bool {
+		return repls[i].rng.Start.Byte < repls[j].rng.Start.Byte
+	})
+
+	var buf bytes.Buffer
+	last := 0
+	for _, repl := range repls {
+		buf.Write(src[last:repl.rng.Start.Byte])
+		buf.Write(repl.src)
+		last = repl.rng.End.Byte
+	}
+	buf.Write(src[last:])
+	return buf.Bytes(), diags
+}
+
+// fileFunction returns an implementation of Terraform's file function, which
+// reads files relative to the given directory, for inlineFileCalls.
+func fileFunction(baseDir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "path", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			path := args[0].AsString()
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+			src, err := ioutil.ReadFile(path)
+			if err != nil {
+				return cty.NilVal, fmt.Errorf("can't read %s: %s", path, err)
+			}
+			if !utf8.Valid(src) {
+				return cty.NilVal, fmt.Errorf("%s is not valid UTF-8 text", path)
+			}
+			return cty.StringVal(string(src)), nil
+		},
+	})
+}
+
+// appendParseDiags is like appendHCLDiags, but for diagnostics from parsing
+// tfvars files, which are subject to FailOnParseWarning.
+func (r *varFileReader) appendParseDiags(diags []tfconfig.Diagnostic, hclDiags hcl.Diagnostics) []tfconfig.Diagnostic {
+	start := len(diags)
+	diags = appendHCLDiags(diags, hclDiags)
+	if r.FailOnParseWarning {
+		for i := start; i < len(diags); i++ {
+			if diags[i].Severity == tfconfig.DiagWarning {
+				diags[i].Severity = tfconfig.DiagError
+			}
+		}
+	}
+	return diags
+}
+
+// normalizeLiterals rewrites any attribute values in the given source that
+// are written as variants of the keywords true, false, or null with the wrong
+// capitalization, like True or NULL, which HCL would otherwise interpret as
+// references.
+//
+// Only whole values are rewritten, not keywords nested inside collections.
+// If the source can't be parsed then it's returned unchanged, so the
+// caller's own parsing can report the problem.
+func normalizeLiterals(src []byte, path string) ([]byte, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	f, hclDiags := hclsyntax.ParseConfig(src, path, hcl.Pos{Line: 1, Column: 1})
+	if hclDiags.HasErrors() {
+		return src, nil
+	}
+
+	type replacement struct {
+		name    string
+		rng     hcl.Range
+		keyword string
+	}
+	var repls []replacement
+	for _, attr := range f.Body.(*hclsyntax.Body).Attributes {
+		expr, ok := attr.Expr.(*hclsyntax.ScopeTraversalExpr)
+		if !ok || len(expr.Traversal) != 1 {
+			continue
+		}
+		name := expr.Traversal.RootName()
+		keyword := strings.ToLower(name)
+		if name == keyword || (keyword != "true" && keyword != "false" && keyword != "null") {
+			continue
+		}
+		repls = append(repls, replacement{attr.Name, expr.SrcRange, keyword})
+	}
+	if len(repls) == 0 {
+		return src, diags
+	}
+	sort.Slice(repls, func(i, j int) bool {
+		return repls[i].rng.Start.Byte < repls[j].rng.Start.Byte
+	})
+
+	var buf bytes.Buffer
+	last := 0
+	for _, repl := range repls {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: diagInfo,
+			Summary:  "Normalized literal value",
+			Detail:   fmt.Sprintf("The value for %q was written as %s, which was rewritten as %s.", repl.name, src[repl.rng.Start.Byte:repl.rng.End.Byte], repl.keyword),
+			Pos: &tfconfig.SourcePos{
+				Filename: path,
+				Line:     repl.rng.Start.Line,
+			},
+		})
+		buf.Write(src[last:repl.rng.Start.Byte])
+		buf.WriteString(repl.keyword)
+		last = repl.rng.End.Byte
+	}
+	buf.Write(src[last:])
+	return buf.Bytes(), diags
+}
+
+// readFileWithRetries is like ioutil.ReadFile except that it retries up to
+// the given number of times, with exponential backoff, if reading fails
+// with a transient error. Other errors are returned immediately.
+func readFileWithRetries(path string, retries int) ([]byte, error) {
+	delay := 100 * time.Millisecond
+	for i := 0; ; i++ {
+		src, err := ioutil.ReadFile(path)
+		if err == nil || i >= retries || !transientError(err) {
+			return src, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// fetchSSHFile reads the file at the given scp:// URL, which has the form
+// scp://[user@]host[:port]/path, using the system's ssh command. The path is
+// taken as absolute on the remote host; a path relative to the remote home
+// directory can be given by starting it with /~/.
+func fetchSSHFile(rawURL string, identity string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" || u.Path == "" || u.Path == "/" {
+		return nil, fmt.Errorf("must have the form scp://[user@]host[:port]/path")
+	}
+
+	var args []string
+	if identity != "" {
+		args = append(args, "-i", identity)
+	}
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	dest := u.Hostname()
+	if u.User != nil {
+		dest = u.User.Username() + "@" + dest
+	}
+	remotePath := u.Path
+	if strings.HasPrefix(remotePath, "/~/") {
+		remotePath = remotePath[3:]
+	}
+	// The remote command is interpreted by the remote user's shell, so the
+	// path must be quoted.
+	args = append(args, "-o", "BatchMode=yes", "--", dest, "cat "+shellQuote(remotePath))
+
+	cmd := exec.Command("ssh", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	src, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("ssh %s failed: %s", dest, msg)
+		}
+		return nil, fmt.Errorf("ssh %s failed: %s", dest, err)
+	}
+	return src, nil
+}
+
+// fetchGitFile reads the file at the given git:// path, which has the form
+// git://path@ref, from the given revision of the Git repository containing
+// the current working directory, using the system's git command. The path is
+// relative to the root of the repository, and the ref can be anything that
+// git accepts as a revision, such as a branch name or commit ID.
+//
+// The path part is also returned, so the caller can choose a syntax based on
+// its filename.
+func fetchGitFile(rawPath string) ([]byte, string, error) {
+	path, ref, err := parseGitPath(rawPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, path, fmt.Errorf("reading %s at %s requires the git command, which isn't installed or isn't in PATH", path, ref)
+	}
+
+	cmd := exec.Command("git", "show", ref+":"+path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	src, err := cmd.Output()
+	if err != nil {
+		// git's messages sometimes end with a period, but ours are
+		// included in a sentence that adds its own.
+		if msg := strings.TrimRight(strings.TrimSpace(stderr.String()), "."); msg != "" {
+			return nil, path, fmt.Errorf("git can't read %s at %s: %s", path, ref, msg)
+		}
+		return nil, path, fmt.Errorf("git can't read %s at %s: %s", path, ref, err)
+	}
+	return src, path, nil
+}
+
+// parseGitPath splits the given git:// path into its path and ref parts, as
+// described for fetchGitFile.
+//
+// The ref becomes the start of an argument to git, so a ref starting with a
+// dash is rejected rather than letting git interpret it as an option. No
+// branch or tag name can start with a dash anyway.
+func parseGitPath(rawPath string) (path, ref string, err error) {
+	spec := strings.TrimPrefix(rawPath, "git://")
+	at := strings.LastIndex(spec, "@")
+	if at < 1 || at == len(spec)-1 {
+		return "", "", fmt.Errorf("must have the form git://path@ref")
+	}
+	path, ref = spec[:at], spec[at+1:]
+	if strings.HasPrefix(ref, "-") {
+		return "", "", fmt.Errorf("the ref %q is invalid, because it starts with a dash", ref)
+	}
+	return path, ref, nil
+}
+
+// remoteVarFile returns true if the given tfvars file path refers to a file
+// that isn't in the local filesystem, and so can't be inspected directly.
+func remoteVarFile(path string) bool {
+	return strings.HasPrefix(path, "scp://") || strings.HasPrefix(path, "git://")
+}
+
+// shellQuote quotes the given string for use as a single word in a POSIX
+// shell command line.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// transientError returns true if the given error from a filesystem operation
+// is one that might not recur if the operation is retried.
+func transientError(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	if temp, ok := err.(interface{ Temporary() bool }); ok {
+		return temp.Temporary()
+	}
+	return false
+}
+
+// ParseJSON parses the given tfvars source code in JSON syntax, converting
+// each of its values to native syntax.
+//
+// JSON has no comments and no way to preserve the original layout of the
+// values, so the converted values are written out in the conventional
+// layout for native syntax.
+func (r *varFileReader) ParseJSON(src []byte, path string) (*filtervars.Source, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	f, hclDiags := hcljson.Parse(src, path)
+	diags = r.appendParseDiags(diags, hclDiags)
+	if hclDiags.HasErrors() {
+		return nil, diags
+	}
+	attrs, hclDiags := f.Body.JustAttributes()
+	diags = r.appendParseDiags(diags, hclDiags)
+	if hclDiags.HasErrors() {
+		return nil, diags
+	}
+
+	// The filterer works with native syntax, so we'll construct native
+	// syntax equivalents of each of the attributes. The hclsyntax body
+	// uses literal values so that we can still refer to the positions of
+	// the original attributes in the JSON source.
+	outF := hclwrite.NewEmptyFile()
+	syntaxBody := &hclsyntax.Body{
+		Attributes: make(hclsyntax.Attributes, len(attrs)),
+		SrcRange:   f.Body.MissingItemRange(),
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return attrs[names[i]].Range.Start.Byte < attrs[names[j]].Range.Start.Byte
+	})
+	for _, name := range names {
+		attr := attrs[name]
+		val, hclDiags := attr.Expr.Value(nil)
+		diags = r.appendParseDiags(diags, hclDiags)
+		if hclDiags.HasErrors() || (r.SkipNullInput && val.IsNull()) {
+			continue
+		}
+		outF.Body().SetAttributeValue(name, val)
+		syntaxBody.Attributes[name] = &hclsyntax.Attribute{
+			Name: name,
+			Expr: &hclsyntax.LiteralValueExpr{
+				Val:      val,
+				SrcRange: attr.Expr.Range(),
+			},
+			SrcRange:  attr.Range,
+			NameRange: attr.NameRange,
+		}
+	}
+
+	return &filtervars.Source{
+		Name:       path,
+		Body:       outF.Body(),
+		SyntaxBody: syntaxBody,
+		FromJSON:   true,
+	}, diags
+}
+
+// expandVarFilePaths replaces each directory in the given list of tfvars
+// file arguments with the tfvars files directly inside it, in lexical order.
+// Whether a path is a directory is decided only by os.Stat, so a path is
+// never treated as both. If recursive is set then the tfvars files in all
+// subdirectories are included too, sorted by their full paths.
+//
+// If the same file then appears more than once, only its last occurrence is
+// kept. Because later files override earlier ones, that gives the same
+// result as processing it at every occurrence, but without doing the work
+// twice.
+func expandVarFilePaths(diags []tfconfig.Diagnostic, args []string, recursive bool) ([]string, []tfconfig.Diagnostic) {
+	var expanded []string
+	for _, arg := range args {
+		path, format := splitVarFileFormat(arg)
+		if remoteVarFile(path) {
+			expanded = append(expanded, arg)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			// Read will report any problem with the file itself.
+			expanded = append(expanded, arg)
+			continue
+		}
+		if format != "" {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Invalid tfvars file argument",
+				Detail:   fmt.Sprintf("The path %s is a directory, so it can't have a :%s suffix.", path, format),
+			})
+			continue
+		}
+
+		files, err := listVarFiles(path, recursive)
+		if err != nil {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Failed to read tfvars directory",
+				Detail:   fmt.Sprintf("Can't read %s: %s.", path, err),
+			})
+			continue
+		}
+		expanded = append(expanded, files...)
+	}
+
+	lastIndex := make(map[string]int, len(expanded))
+	for i, arg := range expanded {
+		path, _ := splitVarFileFormat(arg)
+		lastIndex[filepath.Clean(path)] = i
+	}
+	ret := make([]string, 0, len(lastIndex))
+	for i, arg := range expanded {
+		path, _ := splitVarFileFormat(arg)
+		if lastIndex[filepath.Clean(path)] != i {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: diagInfo,
+				Summary:  "Duplicate tfvars file",
+				Detail:   fmt.Sprintf("The file %s is given more than once, so it's used only in its last position.", path),
+			})
+			continue
+		}
+		ret = append(ret, arg)
+	}
+	return ret, diags
+}
+
+// selectLatestVarFile returns a list containing only the most recently
+// modified of the given tfvars files, for --latest. If more than one file has
+// the same latest modification time then the last of them is selected, for
+// consistency with later files overriding earlier ones.
+func selectLatestVarFile(diags []tfconfig.Diagnostic, args []string) ([]string, []tfconfig.Diagnostic) {
+	latest := -1
+	var latestTime time.Time
+	for i, arg := range args {
+		path, _ := splitVarFileFormat(arg)
+		info, err := os.Stat(path)
+		if err != nil {
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagError,
+				Summary:  "Failed to read input file",
+				Detail:   fmt.Sprintf("Can't read %s: %s.", path, err),
+			})
+			continue
+		}
+		if latest == -1 || !info.ModTime().Before(latestTime) {
+			latest = i
+			latestTime = info.ModTime()
+		}
+	}
+	if latest == -1 {
+		return nil, diags
+	}
+
+	for i, arg := range args {
+		if i == latest {
+			continue
+		}
+		path, _ := splitVarFileFormat(arg)
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: diagInfo,
+			Summary:  "Ignoring older tfvars file",
+			Detail:   fmt.Sprintf("The file %s is ignored, because %s was modified more recently.", path, args[latest]),
+		})
+	}
+	return args[latest : latest+1], diags
+}
+
+// listVarFiles returns the paths of the tfvars files in the given directory,
+// and in all of its subdirectories if recursive is set, sorted by path.
+func listVarFiles(dir string, recursive bool) ([]string, error) {
+	var ret []string
+	if !recursive {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if !info.IsDir() && varFileName(info.Name()) {
+				ret = append(ret, filepath.Join(dir, info.Name()))
+			}
+		}
+		return ret, nil
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && varFileName(info.Name()) {
+			ret = append(ret, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// filepath.Walk visits each directory's entries in lexical order, but
+	// that's not the same as the lexical order of the full paths.
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// varFileName returns true if the given filename has one of the extensions
+// that identify tfvars files when reading a whole directory.
+func varFileName(name string) bool {
+	return strings.HasSuffix(name, ".tfvars") || strings.HasSuffix(name, ".tfvars.json")
+}
+
+// stdinFragmentPattern matches the marker lines that separate the fragments
+// of a --stdin-multi stream, capturing the name of the following fragment.
+var stdinFragmentPattern = regexp.MustCompile(`^#\s*---\s*file:\s*(.+?)\s*---\s*$`)
+
+// stdinFragmentsFilename is the pseudo-filename used for any content before
+// the first marker in a --stdin-multi stream.
+const stdinFragmentsFilename = "<stdin>"
+
+// stdinFragment is one of the logical tfvars files in a --stdin-multi stream.
+type stdinFragment struct {
+	Name string
+	Src  []byte
+}
+
+// splitStdinFragments splits the given stream into separate tfvars files
+// wherever there is a marker line like "# --- file: name ---", naming each
+// file after its marker. Content before the first marker is kept as a file
+// of its own only if it's not just whitespace.
+func splitStdinFragments(src []byte) []stdinFragment {
+	var ret []stdinFragment
+	current := stdinFragment{Name: stdinFragmentsFilename}
+	for _, line := range bytes.SplitAfter(src, []byte{'\n'}) {
+		if m := stdinFragmentPattern.FindSubmatch(bytes.TrimRight(line, "\r\n")); m != nil {
+			if current.Name != stdinFragmentsFilename || len(bytes.TrimSpace(current.Src)) > 0 {
+				ret = append(ret, current)
+			}
+			current = stdinFragment{Name: string(m[1])}
+			continue
+		}
+		current.Src = append(current.Src, line...)
+	}
+	if current.Name != stdinFragmentsFilename || len(bytes.TrimSpace(current.Src)) > 0 {
+		ret = append(ret, current)
+	}
+	return ret
+}
+
+// readManifest reads the list of tfvars file paths from the given manifest
+// file, which has one path per line. Blank lines and lines starting with #
+// are ignored, and a line containing glob metacharacters expands to all of
+// the matching files in lexical order.
+//
+// Relative paths are interpreted relative to the directory containing the
+// manifest.
+func readManifest(path string) ([]string, []tfconfig.Diagnostic) {
+	var diags []tfconfig.Diagnostic
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		diags = append(diags, tfconfig.Diagnostic{
+			Severity: tfconfig.DiagError,
+			Summary:  "Failed to read manifest file",
+			Detail:   fmt.Sprintf("Can't read %s: %s.", path, err),
+		})
+		return nil, diags
+	}
+
+	baseDir := filepath.Dir(path)
+	var ret []string
+	for i, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if remoteVarFile(line) {
+			// Remote paths are neither relative to the manifest nor
+			// patterns to match against the local filesystem.
+			ret = append(ret, line)
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(baseDir, line)
+		}
+
+		if !strings.ContainsAny(line, "*?[") {
+			ret = append(ret, line)
+			continue
+		}
+		matches, err := filepath.Glob(line)
+		if err != nil || len(matches) == 0 {
+			detail := fmt.Sprintf("No files match the pattern %s.", line)
+			if err != nil {
+				detail = fmt.Sprintf("Invalid pattern %s: %s.", line, err)
+			}
+			diags = append(diags, tfconfig.Diagnostic{
+				Severity: tfconfig.DiagWarning,
+				Summary:  "No files match manifest pattern",
+				Detail:   detail,
+				Pos: &tfconfig.SourcePos{
+					Filename: path,
+					Line:     i + 1,
+				},
+			})
+			continue
+		}
+		ret = append(ret, matches...)
+	}
+
+	return ret, diags
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+	"github.com/apparentlymart/terraform-filter-vars/internal/filtertest"
+)
+
+func TestReadAllStableOutput(t *testing.T) {
+	// Each file defines some of the same variables as the others, so the
+	// output depends on the files being merged in argument order no matter
+	// which of the concurrent reads completes first.
+	dir := filtertest.TempDir(t)
+	defer os.RemoveAll(dir)
+	const fileCount = 40
+	var names []string
+	for i := 0; i < 20; i++ {
+		names = append(names, fmt.Sprintf("var_%02d", i))
+	}
+	mod := filtertest.Module(names...)
+	paths := make([]string, fileCount)
+	for i := range paths {
+		var buf bytes.Buffer
+		for j, name := range names {
+			if (i+j)%3 == 0 {
+				fmt.Fprintf(&buf, "%s = \"from file %d\"\n", name, i)
+			}
+		}
+		paths[i] = filtertest.WriteFile(t, dir, fmt.Sprintf("%02d.tfvars", i), buf.String())
+	}
+
+	reader := &varFileReader{}
+	var want []byte
+	for run := 0; run < 50; run++ {
+		sources, diagsByFile := reader.ReadAll(paths, 8)
+		for _, diags := range diagsByFile {
+			filtertest.NoErrors(t, diags)
+		}
+		result, diags := (&filtervars.Filterer{Module: mod}).Filter(sources)
+		filtertest.NoErrors(t, diags)
+		got := filtertest.Output(result)
+
+		if run == 0 {
+			want = got
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("run %d produced different output\ngot:\n%s\nwant:\n%s", run, got, want)
+		}
+	}
+
+	// The concurrent result must also match reading the files one at a
+	// time, in order.
+	sources, _ := reader.ReadAll(paths, 1)
+	result, _ := (&filtervars.Filterer{Module: mod}).Filter(sources)
+	if got := filtertest.Output(result); !bytes.Equal(got, want) {
+		t.Fatalf("sequential read produced different output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestExpandVarFilePathsOverlaps(t *testing.T) {
+	dir := filtertest.TempDir(t)
+	defer os.RemoveAll(dir)
+	a := filtertest.WriteFile(t, dir, "a.tfvars", "")
+	b := filtertest.WriteFile(t, dir, "b.tfvars.json", "{}")
+	filtertest.WriteFile(t, dir, "notes.txt", "")
+	nested := filtertest.WriteFile(t, dir, "sub/c.tfvars", "")
+
+	// A shell glob like dir/* produces the directory's entries, including
+	// any subdirectories, which we simulate with filepath.Glob.
+	globbed, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		args      []string
+		recursive bool
+		want      []string
+	}{
+		"directory only": {
+			[]string{dir},
+			false,
+			[]string{a, b},
+		},
+		"directory only, recursive": {
+			[]string{dir},
+			true,
+			[]string{a, b, nested},
+		},
+		"file then its directory": {
+			// The file is used only in its last position, from the
+			// directory.
+			[]string{b, dir},
+			false,
+			[]string{a, b},
+		},
+		"directory then a file in it": {
+			[]string{dir, a},
+			false,
+			[]string{b, a},
+		},
+		"same file by different paths": {
+			[]string{a, filepath.Join(dir, "sub", "..", "a.tfvars")},
+			false,
+			[]string{filepath.Join(dir, "sub", "..", "a.tfvars")},
+		},
+		"directory and its subdirectory, recursive": {
+			[]string{filepath.Join(dir, "sub"), dir},
+			true,
+			[]string{a, b, nested},
+		},
+		"glob including a subdirectory": {
+			// The subdirectory matched by the glob contributes its files
+			// even without --recursive, but notes.txt is kept as given
+			// because it was named explicitly.
+			globbed,
+			false,
+			[]string{a, b, filepath.Join(dir, "notes.txt"), nested},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, diags := expandVarFilePaths(nil, test.args, test.recursive)
+			filtertest.NoErrors(t, diags)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("wrong result\ngot:  %q\nwant: %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseGitPath(t *testing.T) {
+	tests := map[string]struct {
+		raw      string
+		wantPath string
+		wantRef  string
+		wantErr  string
+	}{
+		"branch": {
+			"git://env/prod.tfvars@main",
+			"env/prod.tfvars", "main", "",
+		},
+		"at sign in the path": {
+			"git://team@example/prod.tfvars@v1.2.0",
+			"team@example/prod.tfvars", "v1.2.0", "",
+		},
+		"relative ref": {
+			"git://prod.tfvars@HEAD~1",
+			"prod.tfvars", "HEAD~1", "",
+		},
+		"no ref": {
+			"git://prod.tfvars",
+			"", "", "must have the form git://path@ref",
+		},
+		"empty ref": {
+			"git://prod.tfvars@",
+			"", "", "must have the form git://path@ref",
+		},
+		"empty path": {
+			"git://@main",
+			"", "", "must have the form git://path@ref",
+		},
+		"option as ref": {
+			"git://x.tfvars@--output=/tmp/pwn",
+			"", "", `the ref "--output=/tmp/pwn" is invalid, because it starts with a dash`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path, ref, err := parseGitPath(test.raw)
+			switch {
+			case test.wantErr != "":
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("wrong error\ngot:  %v\nwant: %s", err, test.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if path != test.wantPath || ref != test.wantRef {
+				t.Errorf("wrong result\ngot:  %q @ %q\nwant: %q @ %q", path, ref, test.wantPath, test.wantRef)
+			}
+		})
+	}
+}
+
+func TestFetchGitFileNoCommand(t *testing.T) {
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+	_, _, err := fetchGitFile("git://env/prod.tfvars@main")
+	want := "reading env/prod.tfvars at main requires the git command, which isn't installed or isn't in PATH"
+	if err == nil || err.Error() != want {
+		t.Errorf("wrong error\ngot:  %v\nwant: %s", err, want)
+	}
+}
+
+func TestCLIGitRefs(t *testing.T) {
+	// commit makes a Git repository of the working directory with all of
+	// its files committed, and then changes a.tfvars without committing
+	// the change.
+	commit := func(t *testing.T, dir string) {
+		if _, err := exec.LookPath("git"); err != nil {
+			t.Skip("git isn't installed")
+		}
+		for _, args := range [][]string{
+			{"init", "-q"},
+			{"add", "."},
+			{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+		} {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = dir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git %s failed: %s\n%s", args[0], err, out)
+			}
+		}
+		filtertest.WriteFile(t, dir, "env/a.tfvars", "region = \"uncommitted\"\n")
+	}
+	files := withTestModule(map[string]string{
+		"env/a.tfvars":      "region = \"us-east-1\"\nextra = 1\n",
+		"env/b.tfvars.json": `{"name": "api"}`,
+	})
+
+	runCLITests(t, map[string]cliTest{
+		"committed file": {
+			Files:      files,
+			Setup:      commit,
+			Args:       []string{"mod", "git://env/a.tfvars@HEAD"},
+			WantStdout: "region = \"us-east-1\"\n",
+		},
+		"committed JSON file": {
+			Files:      files,
+			Setup:      commit,
+			Args:       []string{"mod", "git://env/a.tfvars@HEAD", "git://env/b.tfvars.json@HEAD"},
+			WantStdout: "name   = \"api\"\nregion = \"us-east-1\"\n",
+		},
+		"from a manifest": {
+			Files: func() map[string]string {
+				ret := withTestModule(files)
+				ret["files.txt"] = "git://env/a.tfvars@HEAD\n"
+				return ret
+			}(),
+			Setup:      commit,
+			Args:       []string{"--files-from", "files.txt", "mod", "env/a.tfvars"},
+			WantStdout: "region = \"uncommitted\"\n",
+		},
+		"unknown ref": {
+			Files:      files,
+			Setup:      commit,
+			Args:       []string{"mod", "git://env/a.tfvars@no-such-branch"},
+			WantExit:   1,
+			WantStderr: []string{"Error: Failed to read input file; Can't read git://env/a.tfvars@no-such-branch: git can't read env/a.tfvars at no-such-branch: "},
+		},
+		"option as ref": {
+			Files:      files,
+			Args:       []string{"mod", "git://env/a.tfvars@--output=pwned"},
+			WantExit:   1,
+			WantStderr: []string{`the ref "--output=pwned" is invalid, because it starts with a dash`},
+			Check: func(t *testing.T, dir string) {
+				if _, err := os.Stat(filepath.Join(dir, "pwned")); !os.IsNotExist(err) {
+					t.Error("git wrote to the file named in the ref")
+				}
+			},
+		},
+		"option as ref in a manifest": {
+			Files: func() map[string]string {
+				ret := withTestModule(files)
+				ret["files.txt"] = "git://env/a.tfvars@--output=pwned\n"
+				return ret
+			}(),
+			Args:       []string{"--files-from", "files.txt", "mod"},
+			WantExit:   1,
+			WantStderr: []string{`the ref "--output=pwned" is invalid, because it starts with a dash`},
+		},
+	})
+}
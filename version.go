@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// These are set at build time by the release process.
+var GitCommit string
+var Version = "v0.0.0"
+var Prerelease = "dev"
+
+// buildInfo describes the version of this program, as set at build time.
+type buildInfo struct {
+	Version    string `json:"version"`
+	Prerelease string `json:"prerelease,omitempty"`
+	GitCommit  string `json:"git_commit,omitempty"`
+}
+
+// currentBuildInfo returns the version information for this build.
+func currentBuildInfo() buildInfo {
+	return buildInfo{
+		Version:    Version,
+		Prerelease: Prerelease,
+		GitCommit:  GitCommit,
+	}
+}
+
+// String returns the version string, including any prerelease suffix.
+func (b buildInfo) String() string {
+	if b.Prerelease != "" {
+		return b.Version + "-" + b.Prerelease
+	}
+	return b.Version
+}
+
+// writeVersion writes the version information to the given writer in the
+// given format, which must be either "tfvars" (the default, producing a
+// human-readable line) or "json".
+func writeVersion(w io.Writer, b buildInfo, format string) error {
+	switch format {
+	case "json":
+		src, err := json.MarshalIndent(b, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", src)
+		return err
+	default:
+		_, err := fmt.Fprintf(w, "terraform-filter-vars %s\n", b)
+		return err
+	}
+}
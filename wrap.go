@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// wrapStringTokens replaces the value in the given attribute tokens with a
+// heredoc template whose lines are no more than the given number of
+// characters long, if the value is a string longer than that.
+//
+// The heredoc is an indented one, like <<-EOT, unless every line of the
+// value starts with whitespace. HCL removes the indentation that all of the
+// lines of an indented heredoc have in common, so in that case it would
+// remove some of the value's own whitespace too.
+//
+// This inserts newlines into the value, including a trailing newline at the
+// end of the heredoc, so it's only suitable for strings whose consumers
+// tolerate the extra newlines.
+func wrapStringTokens(toks hclwrite.Tokens, attr *hclwrite.Attribute, width int) hclwrite.Tokens {
+	exprToks := attr.Expr().BuildTokens(nil)
+	if len(exprToks) == 0 {
+		return toks
+	}
+	val, diags := attributeValue(attr)
+	if diags.HasErrors() || val.Type() != cty.String || val.IsNull() || !val.IsKnown() {
+		return toks
+	}
+	str := val.AsString()
+	if len([]rune(str)) <= width {
+		return toks
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(str, "\n"), "\n") {
+		runes := []rune(line)
+		for len(runes) > width {
+			lines = append(lines, string(runes[:width]))
+			runes = runes[width:]
+		}
+		lines = append(lines, string(runes))
+	}
+
+	// The closing marker must not appear alone on any line of the content,
+	// or it would end the heredoc early.
+	marker := "EOT"
+	for {
+		conflict := false
+		for _, line := range lines {
+			if strings.TrimSpace(line) == marker {
+				conflict = true
+				break
+			}
+		}
+		if !conflict {
+			break
+		}
+		marker += "_"
+	}
+
+	// Lines that are empty or entirely whitespace aren't considered when
+	// HCL decides how much indentation to remove, and are left as-is, so
+	// they mustn't be indented.
+	blank := func(line string) bool {
+		return strings.TrimLeftFunc(line, unicode.IsSpace) == ""
+	}
+	indent := ""
+	for _, line := range lines {
+		if !blank(line) && !unicode.IsSpace([]rune(line)[0]) {
+			indent = "  "
+			break
+		}
+	}
+	opener := "<<" + marker
+	if indent != "" {
+		opener = "<<-" + marker
+	}
+
+	heredoc := hclwrite.Tokens{
+		{Type: hclsyntax.TokenOHeredoc, Bytes: []byte(opener + "\n"), SpacesBefore: 1},
+	}
+	for _, line := range lines {
+		if !blank(line) {
+			line = indent + line
+		}
+		line = strings.Replace(line, "${", "$${", -1)
+		line = strings.Replace(line, "%{", "%%{", -1)
+		heredoc = append(heredoc, &hclwrite.Token{Type: hclsyntax.TokenStringLit, Bytes: []byte(line + "\n")})
+	}
+	heredoc = append(heredoc, &hclwrite.Token{Type: hclsyntax.TokenCHeredoc, Bytes: []byte(indent + marker)})
+
+	// The expression tokens are a contiguous subsequence of the attribute's
+	// tokens, so we can splice the heredoc in their place.
+	start := -1
+	for i, tok := range toks {
+		if tok == exprToks[0] {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return toks
+	}
+	end := start + len(exprToks)
+	ret := make(hclwrite.Tokens, 0, len(toks)-len(exprToks)+len(heredoc)+1)
+	ret = append(ret, toks[:start]...)
+	ret = append(ret, heredoc...)
+	rest := toks[end:]
+	if end >= len(toks) || toks[end].Type != hclsyntax.TokenNewline {
+		// The closing marker must be alone on its line, so anything that
+		// followed the value, such as a comment, must move to the next line.
+		ret = append(ret, &hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte{'\n'}})
+		if end < len(toks) {
+			// The tokens are shared with the result, so we use a copy
+			// rather than changing its spacing in-place.
+			moved := *toks[end]
+			moved.SpacesBefore = 0
+			ret = append(ret, &moved)
+			rest = toks[end+1:]
+		}
+	}
+	return append(ret, rest...)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+
+	"github.com/apparentlymart/terraform-filter-vars/filtervars"
+	"github.com/apparentlymart/terraform-filter-vars/internal/filtertest"
+)
+
+func TestCLIWrapStrings(t *testing.T) {
+	files := map[string]string{
+		"mod/variables.tf": "variable \"a\" {}\nvariable \"b\" {}\nvariable \"c\" {}\n",
+	}
+	withInput := func(input string) map[string]string {
+		ret := map[string]string{"in.tfvars": input}
+		for path, content := range files {
+			ret[path] = content
+		}
+		return ret
+	}
+
+	// checkValues returns a Check function that verifies that the values
+	// in out.tfvars are the given ones, which are the original values with
+	// newlines inserted where they were wrapped.
+	checkValues := func(want map[string]string) func(*testing.T, string) {
+		return func(t *testing.T, dir string) {
+			src, err := ioutil.ReadFile(filepath.Join(dir, "out.tfvars"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, hclDiags := hclwrite.ParseConfig(src, "out.tfvars", hcl.Pos{Line: 1, Column: 1})
+			if hclDiags.HasErrors() {
+				t.Fatalf("invalid output: %s", hclDiags.Error())
+			}
+			for name, wantVal := range want {
+				val, hclDiags := attributeValue(f.Body().GetAttribute(name))
+				if hclDiags.HasErrors() {
+					t.Errorf("can't evaluate %q: %s", name, hclDiags.Error())
+					continue
+				}
+				if got := val.AsString(); got != wantVal {
+					t.Errorf("wrong value for %q\ngot:  %q\nwant: %q", name, got, wantVal)
+				}
+			}
+		}
+	}
+
+	runCLITests(t, map[string]cliTest{
+		"long and short strings": {
+			Files: withInput("a = \"the quick brown fox jumps\"\nb = \"short\"\nc = 12345678901234\n"),
+			Args:  []string{"--wrap-strings", "10", "-o", "out.tfvars", "mod", "in.tfvars"},
+			WantFiles: map[string]string{
+				"out.tfvars": "a = <<-EOT\n  the quick \n  brown fox \n  jumps\n  EOT\nb = \"short\"\nc = 12345678901234\n",
+			},
+			Check: checkValues(map[string]string{
+				"a": "the quick \nbrown fox \njumps\n",
+				"b": "short",
+			}),
+		},
+		"comment after the value": {
+			Files: withInput("a = \"the quick brown fox\" # comment\n"),
+			Args:  []string{"--wrap-strings", "10", "-o", "out.tfvars", "mod", "in.tfvars"},
+			WantFiles: map[string]string{
+				"out.tfvars": "a = <<-EOT\n  the quick \n  brown fox\n  EOT\n# comment\n",
+			},
+		},
+		"blank lines": {
+			Files: withInput("a = \"first line\\n\\n   \\nlast line\"\n"),
+			Args:  []string{"--wrap-strings", "10", "-o", "out.tfvars", "mod", "in.tfvars"},
+			WantFiles: map[string]string{
+				"out.tfvars": "a = <<-EOT\n  first line\n\n   \n  last line\n  EOT\n",
+			},
+			Check: checkValues(map[string]string{
+				"a": "first line\n\n   \nlast line\n",
+			}),
+		},
+		"every line indented": {
+			// An indented heredoc would lose some of the value's own
+			// indentation, so this one isn't indented.
+			Files: withInput("a = \"  every line\\n  indented\"\n"),
+			Args:  []string{"--wrap-strings", "12", "-o", "out.tfvars", "mod", "in.tfvars"},
+			WantFiles: map[string]string{
+				"out.tfvars": "a = <<EOT\n  every line\n  indented\nEOT\n",
+			},
+			Check: checkValues(map[string]string{
+				"a": "  every line\n  indented\n",
+			}),
+		},
+		"some lines indented": {
+			Files: withInput("a = \"  indented\\nflush\"\n"),
+			Args:  []string{"--wrap-strings", "8", "-o", "out.tfvars", "mod", "in.tfvars"},
+			Check: checkValues(map[string]string{
+				"a": "  indent\ned\nflush\n",
+			}),
+		},
+		"closing marker in the value": {
+			Files: withInput("a = \"EOT\\nand more after it\"\n"),
+			Args:  []string{"--wrap-strings", "10", "-o", "out.tfvars", "mod", "in.tfvars"},
+			WantFiles: map[string]string{
+				"out.tfvars": "a = <<-EOT_\n  EOT\n  and more a\n  fter it\n  EOT_\n",
+			},
+			Check: checkValues(map[string]string{
+				"a": "EOT\nand more a\nfter it\n",
+			}),
+		},
+	})
+}
+
+func TestWrapStringTokensShared(t *testing.T) {
+	// The tokens of the attributes in a result are shared with anything
+	// else that uses the result, so wrapping mustn't modify them.
+	src := filtertest.Source(t, "in.tfvars", "a = \"the quick brown fox\"   # comment\n")
+	result, diags := (&filtervars.Filterer{Module: filtertest.Module("a")}).Filter([]*filtervars.Source{src})
+	filtertest.NoErrors(t, diags)
+	attr := result.Attrs["a"]
+	before := string(attr.BuildTokens(nil).Bytes())
+
+	wrapped := wrapStringTokens(attr.BuildTokens(nil), attr, 10)
+	if got, want := string(wrapped.Bytes()), "a = <<-EOT\n  the quick \n  brown fox\n  EOT\n# comment\n"; got != want {
+		t.Errorf("wrong wrapped tokens\ngot:  %q\nwant: %q", got, want)
+	}
+	if after := string(attr.BuildTokens(nil).Bytes()); after != before {
+		t.Errorf("attribute tokens were modified\ngot:  %q\nwant: %q", after, before)
+	}
+}